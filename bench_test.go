@@ -0,0 +1,189 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+)
+
+var benchLevels = []int{1, 3, 9, 19}
+
+// benchPayloads are about 256kb each.
+var benchPayloads = func() []struct {
+	name string
+	data []byte
+} {
+	const size = 256 << 10
+	rnd := rand.New(rand.NewSource(1))
+	words := strings.Fields("the quick brown fox jumps over a lazy dog while zstd compresses text into fewer bytes than it started with")
+
+	var text []byte
+	for len(text) < size {
+		text = append(text, words[rnd.Intn(len(words))]...)
+		text = append(text, ' ')
+	}
+	var json []byte
+	for len(json) < size {
+		for _, rec := range samples(100, rnd.Int63()) {
+			json = append(json, rec...)
+			json = append(json, '\n')
+		}
+	}
+	random := make([]byte, size)
+	rnd.Read(random)
+
+	return []struct {
+		name string
+		data []byte
+	}{
+		{"text", text[:size]},
+		{"json", json[:size]},
+		{"random", random},
+	}
+}()
+
+func BenchmarkCompress(b *testing.B) {
+	for _, p := range benchPayloads {
+		for _, level := range benchLevels {
+			b.Run(fmt.Sprintf("%s/level=%d", p.name, level), func(b *testing.B) {
+				c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CompressionLevel: level})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer c.Delete()
+				dst := make([]byte, zstdwrap.CompressBound(len(p.data)))
+				b.SetBytes(int64(len(p.data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.Compress(dst[:0], p.data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkLiteralCompressionMode(b *testing.B) {
+	for _, p := range benchPayloads {
+		if p.name != "random" {
+			continue
+		}
+		for _, mode := range []struct {
+			name string
+			lcm  zstdwrap.LiteralCompressionMode
+		}{
+			{"Auto", zstdwrap.LiteralAuto},
+			{"Huffman", zstdwrap.LiteralHuffman},
+			{"Uncompressed", zstdwrap.LiteralUncompressed},
+		} {
+			b.Run(p.name+"/"+mode.name, func(b *testing.B) {
+				c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{LiteralCompressionMode: mode.lcm})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer c.Delete()
+				dst := make([]byte, zstdwrap.CompressBound(len(p.data)))
+				b.SetBytes(int64(len(p.data)))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.Compress(dst[:0], p.data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, p := range benchPayloads {
+		for _, level := range benchLevels {
+			b.Run(fmt.Sprintf("%s/level=%d", p.name, level), func(b *testing.B) {
+				frame, err := zstdwrap.Compress(nil, p.data, level)
+				if err != nil {
+					b.Fatal(err)
+				}
+				d, err := zstdwrap.NewDecompressor(0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer d.Delete()
+				dst := make([]byte, len(p.data))
+				b.SetBytes(int64(len(p.data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := d.Decompress(dst[:0], frame); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkCompressBound compares the pure Go CompressBound
+// with a cgo call to ZSTD_compressBound.
+func BenchmarkCompressBound(b *testing.B) {
+	b.Run("go", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			zstdwrap.CompressBound(i)
+		}
+	})
+	b.Run("cgo", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			zstdwrap.CompressBoundC(i)
+		}
+	})
+}
+
+func TestReuseAllocs(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	for _, p := range benchPayloads {
+		cbuf := make([]byte, zstdwrap.CompressBound(len(p.data)))
+		frame, err := c.Compress(nil, p.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbuf := make([]byte, len(p.data))
+		// Enough runs that the occasional allocation by the
+		// runtime, such as for a cgo call on a new thread,
+		// averages out below one per run.
+		const runs = 100
+		if allocs := testing.AllocsPerRun(runs, func() { c.Compress(cbuf[:0], p.data) }); allocs != 0 {
+			t.Errorf("%s: Compress with a reused dst: %v allocs", p.name, allocs)
+		}
+		if allocs := testing.AllocsPerRun(runs, func() { d.Decompress(dbuf[:0], frame) }); allocs != 0 {
+			t.Errorf("%s: Decompress with a reused dst: %v allocs", p.name, allocs)
+		}
+	}
+}
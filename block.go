@@ -0,0 +1,148 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #include <stdlib.h>
+// #include "zstd.h"
+import "C"
+import (
+	"unsafe"
+
+	"golang.org/x/xerrors"
+)
+
+// The block API produces raw blocks with no frame header,
+// for containers that do their own framing.
+//
+// zstd keeps pointers to the previous block for matches that
+// cross blocks. So each block is held in C memory until the
+// one after next, the oldest a non-contiguous block can match.
+// Those copies are released by the next BeginBlocks or Delete.
+//
+// The bundled zstd has no sequence-level API: ZSTD_getSequences,
+// ZSTD_generateSequences, and ZSTD_compressSequences arrived in
+// later releases. Blocks are the lowest level at which callers can
+// control compression, and matches are always found by zstd.
+
+// blockHistory is the C memory referenced by a block session.
+type blockHistory [2]unsafe.Pointer
+
+func (h *blockHistory) add(p unsafe.Pointer) {
+	C.free(h[0])
+	h[0], h[1] = h[1], p
+}
+
+func (h *blockHistory) free() {
+	C.free(h[0])
+	C.free(h[1])
+	h[0], h[1] = nil, nil
+}
+
+// BeginBlocks starts a block session at the given compression
+// level, with ZSTD_compressBegin. Compressor options do not
+// apply to blocks.
+func (c *Compressor) BeginBlocks(level int) error {
+	if err := checkLevel("BeginBlocks", level); err != nil {
+		return err
+	}
+	c.blocks.free()
+	return isErr("BeginBlocks", C.ZSTD_compressBegin(c.ctx, C.int(level)))
+}
+
+// BlockSize reports the largest src CompressBlock accepts,
+// with ZSTD_getBlockSize. It is at most 128kb.
+// BeginBlocks must be called first.
+func (c *Compressor) BlockSize() int {
+	return int(C.ZSTD_getBlockSize(c.ctx))
+}
+
+// CompressBlock compresses src as a raw block with
+// ZSTD_compressBlock into dst, and returns the new dst.
+// The len(src) must not exceed BlockSize.
+//
+// Blocks are decompressed with Decompressor.DecompressBlock, in
+// the order they were compressed. If src does not compress,
+// CompressBlock returns an empty dst. The caller must then store
+// src as is, and pass it to Decompressor.InsertBlock.
+func (c *Compressor) CompressBlock(dst, src []byte) ([]byte, error) {
+	if len(src) > c.BlockSize() {
+		return nil, xerrors.Errorf("zstdwrap.CompressBlock: %d bytes, block size is %d: %w", len(src), c.BlockSize(), ErrSrcSizeWrong)
+	}
+	if len(src) == 0 {
+		return dst[:0], nil
+	}
+	if need := CompressBound(len(src)); cap(dst) < need {
+		dst = append(dst, make([]byte, need-len(dst))...)
+	} else {
+		dst = dst[:need]
+	}
+	srcv := C.CBytes(src)
+	res := C.ZSTD_compressBlock(c.ctx, unsafe.Pointer(&dst[0]), C.size_t(len(dst)), srcv, C.size_t(len(src)))
+	if err := isErr("CompressBlock", res); err != nil {
+		C.free(srcv)
+		return nil, err
+	}
+	c.blocks.add(srcv)
+	return dst[:int(res)], nil
+}
+
+// BeginBlocks starts a block session with ZSTD_decompressBegin.
+func (d *Decompressor) BeginBlocks() error {
+	d.blocks.free()
+	return isErr("BeginBlocks", C.ZSTD_decompressBegin(d.ctx))
+}
+
+// DecompressBlock decompresses a block from CompressBlock with
+// ZSTD_decompressBlock into dst, and returns the new dst.
+func (d *Decompressor) DecompressBlock(dst, src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, xerrors.Errorf("zstdwrap.DecompressBlock: %w", ErrSrcSizeWrong)
+	}
+	out := C.malloc(C.ZSTD_BLOCKSIZE_MAX)
+	if out == nil {
+		return nil, xerrors.Errorf("zstdwrap.DecompressBlock: %w", ErrMemoryAllocation)
+	}
+	res := C.ZSTD_decompressBlock(d.ctx, out, C.ZSTD_BLOCKSIZE_MAX, unsafe.Pointer(&src[0]), C.size_t(len(src)))
+	if err := isErr("DecompressBlock", res); err != nil {
+		C.free(out)
+		return nil, err
+	}
+	d.blocks.add(out)
+	n := int(res)
+	if cap(dst) < n {
+		dst = make([]byte, n)
+	}
+	dst = dst[:n]
+	copy(dst, (*[C.ZSTD_BLOCKSIZE_MAX]byte)(out)[:n:n])
+	return dst, nil
+}
+
+// InsertBlock adds an uncompressed block to the history
+// of the block session, with ZSTD_insertBlock.
+// See Compressor.CompressBlock.
+func (d *Decompressor) InsertBlock(block []byte) error {
+	if len(block) == 0 {
+		return nil
+	}
+	p := C.CBytes(block)
+	res := C.ZSTD_insertBlock(d.ctx, p, C.size_t(len(block)))
+	if err := isErr("InsertBlock", res); err != nil {
+		C.free(p)
+		return err
+	}
+	d.blocks.add(p)
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+)
+
+func TestBlocks(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 1000)
+	rng.Read(random)
+	text := []byte(strings.Repeat("block of text, ", 500))
+	var mixed []byte
+	for len(mixed) < 4000 {
+		mixed = append(mixed, byte('a'+rng.Intn(16)))
+	}
+	srcs := [][]byte{
+		mixed,
+		mixed, // matches the previous block
+		random,
+		text,
+	}
+
+	if err := c.BeginBlocks(3); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.BlockSize(); n <= 0 || n > 128<<10 {
+		t.Fatalf("BlockSize()=%d", n)
+	}
+	var blocks [][]byte
+	for _, src := range srcs {
+		block, err := c.CompressBlock(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks[1]) >= len(blocks[0])/10 {
+		t.Errorf("repeated block compressed to %d bytes, first %d", len(blocks[1]), len(blocks[0]))
+	}
+	if len(blocks[2]) != 0 {
+		t.Errorf("random block compressed to %d bytes, want 0 (incompressible)", len(blocks[2]))
+	}
+
+	if err := d.BeginBlocks(); err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range blocks {
+		var got []byte
+		if len(block) == 0 {
+			got = srcs[i]
+			if err := d.InsertBlock(got); err != nil {
+				t.Fatal(err)
+			}
+		} else if got, err = d.DecompressBlock(nil, block); err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		if !bytes.Equal(got, srcs[i]) {
+			t.Errorf("block %d: round trip mismatch", i)
+		}
+	}
+
+	if _, err := c.CompressBlock(nil, make([]byte, c.BlockSize()+1)); err == nil {
+		t.Error("CompressBlock accepted src larger than BlockSize")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+import "io"
+
+// CopyCompress compresses everything read from src until EOF into
+// a single frame written to dst, as io.Copy through a Writer
+// configured with opts. It reports the number of uncompressed
+// bytes read from src.
+func CopyCompress(dst io.Writer, src io.Reader, opts *COptions) (int64, error) {
+	w, err := NewWriter(dst, opts)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.ReadFrom(src)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// CopyDecompress decompresses the frames read from src until EOF,
+// writing their content to dst, as io.Copy from a Reader with
+// windowLogMax. It reports the number of decompressed bytes
+// written to dst.
+func CopyDecompress(dst io.Writer, src io.Reader, windowLogMax int) (int64, error) {
+	r, err := NewReader(src, windowLogMax)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(dst, r)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+)
+
+func TestCopy(t *testing.T) {
+	src := make([]byte, 3<<20)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range src {
+		src[i] = byte('a' + rnd.Intn(8))
+	}
+
+	var compressed bytes.Buffer
+	n, err := zstdwrap.CopyCompress(&compressed, bytes.NewReader(src), &zstdwrap.COptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(src)) {
+		t.Errorf("CopyCompress n=%d, want %d", n, len(src))
+	}
+	if compressed.Len() >= len(src) {
+		t.Errorf("compressed to %d bytes, want fewer than %d", compressed.Len(), len(src))
+	}
+
+	var got bytes.Buffer
+	n, err = zstdwrap.CopyDecompress(&got, &compressed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(src)) {
+		t.Errorf("CopyDecompress n=%d, want %d", n, len(src))
+	}
+	if !bytes.Equal(got.Bytes(), src) {
+		t.Error("round trip mismatch")
+	}
+}
@@ -0,0 +1,404 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #define ZDICT_STATIC_LINKING_ONLY
+// #include <stdlib.h>
+// #include "zstd.h"
+// #include "zdict.h"
+import "C"
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/xerrors"
+)
+
+// cBytes is a copy of dictionary bytes in C memory, for zstd
+// to reference after a load with ZSTD_dlm_byRef. cgo does not
+// allow C to keep a pointer to Go memory once a call returns.
+//
+// A cBytes is reference counted, so the Clones of a Compressor
+// share one copy. It is freed when the last reference is released.
+type cBytes struct {
+	p    unsafe.Pointer
+	n    int
+	refs int32
+}
+
+func newCBytes(b []byte) *cBytes {
+	return &cBytes{p: C.CBytes(b), n: len(b), refs: 1}
+}
+
+func (b *cBytes) acquire() *cBytes {
+	atomic.AddInt32(&b.refs, 1)
+	return b
+}
+
+func releaseCBytes(b **cBytes) {
+	if *b != nil {
+		if atomic.AddInt32(&(*b).refs, -1) == 0 {
+			C.free((*b).p)
+		}
+		*b = nil
+	}
+}
+
+// CDict is a digested compression dictionary.
+//
+// Digesting a dictionary is expensive. A CDict does it once
+// so the result can be shared by many Compressors through
+// COptions.CDict. A CDict must outlive every Compressor
+// that references it.
+//
+// The bundled zstd predates ZSTD_c_enableDedicatedDictSearch,
+// so a CDict is always digested for the regular match finder.
+// Sharing one CDict still saves each Compressor from digesting
+// its own copy of COptions.Dictionary; see
+// BenchmarkDictionarySmall.
+type CDict struct {
+	cdict *C.ZSTD_CDict
+	id    uint32 // GetDictIDFromDict, for FrameOverhead
+}
+
+// NewCDict digests dict for compression at level with
+// ZSTD_createCDict. The bytes are copied.
+//
+// Compressors that reference the CDict use its compression
+// level, not COptions.CompressionLevel.
+func NewCDict(dict []byte, level int) (*CDict, error) {
+	if len(dict) == 0 {
+		return nil, errors.New("zstdwrap.NewCDict: empty dictionary")
+	}
+	cd := &CDict{
+		cdict: C.ZSTD_createCDict(unsafe.Pointer(&dict[0]), C.size_t(len(dict)), C.int(level)),
+		id:    GetDictIDFromDict(dict),
+	}
+	if cd.cdict == nil {
+		if err := checkDict("NewCDict", dict); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("zstdwrap: ZSTD_createCDict failed")
+	}
+	return cd, nil
+}
+
+func (cd *CDict) Delete() error {
+	err := isErr("Delete", C.ZSTD_freeCDict(cd.cdict))
+	cd.cdict = nil
+	return err
+}
+
+// DDict is a digested decompression dictionary.
+//
+// It is shared by many Decompressors through RefDDict.
+// A DDict must outlive every Decompressor that references it.
+type DDict struct {
+	ddict *C.ZSTD_DDict
+}
+
+// NewDDict digests dict with ZSTD_createDDict. The bytes are copied.
+func NewDDict(dict []byte) (*DDict, error) {
+	if len(dict) == 0 {
+		return nil, errors.New("zstdwrap.NewDDict: empty dictionary")
+	}
+	dd := &DDict{
+		ddict: C.ZSTD_createDDict(unsafe.Pointer(&dict[0]), C.size_t(len(dict))),
+	}
+	if dd.ddict == nil {
+		if err := checkDict("NewDDict", dict); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("zstdwrap: ZSTD_createDDict failed")
+	}
+	return dd, nil
+}
+
+func (dd *DDict) Delete() error {
+	err := isErr("Delete", C.ZSTD_freeDDict(dd.ddict))
+	dd.ddict = nil
+	return err
+}
+
+// GetDictIDFromDict reports the ID stored in a structured
+// dictionary, as produced by TrainDictionary, with
+// ZSTD_getDictID_fromDict. It reports 0 for raw content.
+//
+// Comparing it with GetDictIDFromFrame finds a dictionary
+// mismatch before decompressing.
+func GetDictIDFromDict(dict []byte) uint32 {
+	if len(dict) == 0 {
+		return 0
+	}
+	return uint32(C.ZSTD_getDictID_fromDict(unsafe.Pointer(&dict[0]), C.size_t(len(dict))))
+}
+
+// ValidateDictionary reports whether dict can be used for both
+// compression and decompression, so a bad dictionary is rejected
+// when it is configured rather than on first use.
+//
+// A structured dictionary, one starting with the dictionary magic
+// number, must record an ID and its entropy tables must parse.
+// Any other non-empty buffer is valid raw content. Corrupted
+// dictionaries report ErrDictionaryCorrupted.
+//
+// The dictionary is loaded into a throwaway context with
+// ZSTD_CCtx_loadDictionary and digested by compressing an empty
+// frame, as a Compressor would on first use.
+func ValidateDictionary(dict []byte) error {
+	if len(dict) == 0 {
+		return errors.New("zstdwrap.ValidateDictionary: empty dictionary")
+	}
+	if len(dict) >= 4 && binary.LittleEndian.Uint32(dict) == C.ZSTD_MAGIC_DICTIONARY && GetDictIDFromDict(dict) == 0 {
+		return xerrors.Errorf("zstdwrap.ValidateDictionary: no dictionary ID: %w", ErrDictionaryCorrupted)
+	}
+	cctx := C.ZSTD_createCCtx()
+	if cctx == nil {
+		return xerrors.Errorf("zstdwrap.ValidateDictionary: %w", ErrMemoryAllocation)
+	}
+	defer C.ZSTD_freeCCtx(cctx)
+	err := isErr("ValidateDictionary", C.ZSTD_CCtx_loadDictionary(cctx, unsafe.Pointer(&dict[0]), C.size_t(len(dict))))
+	if err == nil {
+		dst := make([]byte, CompressBound(0))
+		err = isErr("ValidateDictionary", C.ZSTD_compress2(cctx, unsafe.Pointer(&dst[0]), C.size_t(len(dst)), nil, 0))
+	}
+	if err == nil {
+		err = checkDict("ValidateDictionary", dict)
+	}
+	if err != nil && !xerrors.Is(err, ErrDictionaryCorrupted) {
+		// Report any failure to digest the tables as corruption.
+		return xerrors.Errorf("zstdwrap.ValidateDictionary: %v: %w", err, ErrDictionaryCorrupted)
+	}
+	return err
+}
+
+// checkDict reports why dict cannot be loaded.
+//
+// Creating a CDict or DDict from a corrupted dictionary reports
+// an allocation failure. ZSTD_decompressBegin_usingDict parses
+// the same entropy tables and reports ErrDictionaryCorrupted.
+func checkDict(loc string, dict []byte) error {
+	if len(dict) < 4 || binary.LittleEndian.Uint32(dict) != C.ZSTD_MAGIC_DICTIONARY {
+		return nil // raw content dictionary
+	}
+	dctx := C.ZSTD_createDCtx()
+	if dctx == nil {
+		return xerrors.Errorf("zstdwrap.%s: %w", loc, ErrMemoryAllocation)
+	}
+	defer C.ZSTD_freeDCtx(dctx)
+	return isErr(loc, C.ZSTD_decompressBegin_usingDict(dctx, unsafe.Pointer(&dict[0]), C.size_t(len(dict))))
+}
+
+// checkDictType reports why dict cannot be loaded as ct.
+func checkDictType(loc string, dict []byte, ct DictContentType) error {
+	switch ct {
+	case DictContentRaw:
+		return nil
+	case DictContentFull:
+		if len(dict) < 4 || binary.LittleEndian.Uint32(dict) != C.ZSTD_MAGIC_DICTIONARY {
+			return xerrors.Errorf("zstdwrap.%s: not a structured dictionary: %w", loc, ErrDictionaryWrong)
+		}
+	}
+	return checkDict(loc, dict)
+}
+
+// DictContentType selects how LoadDictionaryAdvanced
+// interprets a dictionary.
+type DictContentType int
+
+const (
+	// DictContentAuto treats a dictionary that starts with the
+	// zstd dictionary magic number as structured, as produced by
+	// TrainDictionary, and anything else as raw content.
+	DictContentAuto DictContentType = C.ZSTD_dct_auto
+
+	// DictContentRaw treats the whole dictionary as raw
+	// content, even if it starts with the magic number.
+	DictContentRaw DictContentType = C.ZSTD_dct_rawContent
+
+	// DictContentFull requires a structured dictionary.
+	// Anything else reports ErrDictionaryWrong.
+	DictContentFull DictContentType = C.ZSTD_dct_fullDict
+)
+
+// DictLoadMethod selects whether LoadDictionaryAdvanced
+// copies a dictionary.
+type DictLoadMethod int
+
+const (
+	DictLoadByCopy DictLoadMethod = C.ZSTD_dlm_byCopy
+
+	// DictLoadByRef makes zstd reference the dictionary bytes
+	// rather than copy them into its context. C code cannot
+	// keep a reference to Go memory, so the bytes are copied
+	// once into C memory owned by the Compressor or
+	// Decompressor, and freed when the dictionary is replaced
+	// or on Delete. Only Clones of a Compressor share the copy.
+	DictLoadByRef DictLoadMethod = C.ZSTD_dlm_byRef
+)
+
+// LoadDictionaryAdvanced loads dict with
+// ZSTD_CCtx_loadDictionary_advanced, replacing any Dictionary
+// or CDict. The dictionary is used for all subsequent frames.
+// A nil or empty dict returns the Compressor to
+// no-dictionary mode.
+//
+// A raw-content dictionary that happens to start with the
+// dictionary magic number must be loaded with DictContentRaw,
+// on both the Compressor and the Decompressor.
+func (c *Compressor) LoadDictionaryAdvanced(dict []byte, contentType DictContentType, loadMethod DictLoadMethod) error {
+	if err := checkDictType("LoadDictionaryAdvanced", dict, contentType); err != nil {
+		return err
+	}
+	return c.loadDictionary("LoadDictionaryAdvanced", dict, contentType, loadMethod)
+}
+
+// LoadDictionaryAdvanced loads dict with
+// ZSTD_DCtx_loadDictionary_advanced. It is LoadDictionary
+// with control over how dict is interpreted and whether
+// it is copied.
+func (d *Decompressor) LoadDictionaryAdvanced(dict []byte, contentType DictContentType, loadMethod DictLoadMethod) error {
+	return d.loadDictionary("LoadDictionaryAdvanced", dict, contentType, loadMethod)
+}
+
+// isDictErr is isErr for the results of ZDICT functions.
+//
+// The ZDICT trainers report most failures, such as too few
+// samples, as ErrGeneric. They are reported as
+// ErrDictionaryCreationFailed.
+func isDictErr(loc string, res C.size_t) error {
+	if C.ZDICT_isError(res) == 0 {
+		return nil
+	}
+	err := isErr("", res)
+	if err == ErrGeneric {
+		err = ErrDictionaryCreationFailed
+	}
+	return xerrors.Errorf("zstdwrap.%s: %w", loc, err)
+}
+
+// TrainDictionary builds a dictionary of at most dictCapacity
+// bytes from samples with ZDICT_trainFromBuffer.
+//
+// Training works best with thousands of samples whose total
+// size is roughly a hundred times dictCapacity.
+// A dictCapacity below 256 bytes reports ErrDstSizeTooSmall.
+func TrainDictionary(dictCapacity int, samples [][]byte) ([]byte, error) {
+	if dictCapacity < C.ZDICT_DICTSIZE_MIN {
+		return nil, xerrors.Errorf("zstdwrap.TrainDictionary: capacity %d less than %d: %w", dictCapacity, C.ZDICT_DICTSIZE_MIN, ErrDstSizeTooSmall)
+	}
+	flat, sizes := flattenSamples(samples)
+	if len(flat) == 0 {
+		return nil, errors.New("zstdwrap.TrainDictionary: no samples")
+	}
+	dict := make([]byte, dictCapacity)
+	res := C.ZDICT_trainFromBuffer(
+		unsafe.Pointer(&dict[0]), C.size_t(len(dict)),
+		unsafe.Pointer(&flat[0]), &sizes[0], C.uint(len(sizes)))
+	if err := isDictErr("TrainDictionary", res); err != nil {
+		return nil, err
+	}
+	return dict[:int(res)], nil
+}
+
+// flattenSamples concatenates samples into the flat buffer
+// and size array used by the ZDICT training functions.
+func flattenSamples(samples [][]byte) (flat []byte, sizes []C.size_t) {
+	n := 0
+	for _, s := range samples {
+		n += len(s)
+	}
+	flat = make([]byte, 0, n)
+	sizes = make([]C.size_t, len(samples))
+	for i, s := range samples {
+		flat = append(flat, s...)
+		sizes[i] = C.size_t(len(s))
+	}
+	return flat, sizes
+}
+
+// CoverParams configures TrainDictionaryCover.
+type CoverParams struct {
+	K     int // segment size, 0 to search
+	D     int // dmer size, 0 to search
+	Steps int // number of parameters tried by the search, 0 for default
+
+	// CompressionLevel the dictionary is optimized for.
+	// Zero means the zstd default.
+	CompressionLevel int
+
+	// Fast selects the fastCover algorithm over COVER.
+	Fast bool
+}
+
+// TrainDictionaryCover builds a dictionary of at most dictCapacity
+// bytes from samples using the COVER or fastCover algorithm.
+//
+// If both params.K and params.D are set, the dictionary is trained
+// with ZDICT_trainFromBuffer_cover or ZDICT_trainFromBuffer_fastCover.
+// Otherwise ZDICT_optimizeTrainFromBuffer_cover or
+// ZDICT_optimizeTrainFromBuffer_fastCover search for good values.
+//
+// The parameters used are returned, so they can be reused
+// in later training runs without repeating the search.
+func TrainDictionaryCover(dictCapacity int, samples [][]byte, params CoverParams) ([]byte, CoverParams, error) {
+	if dictCapacity < C.ZDICT_DICTSIZE_MIN {
+		return nil, params, xerrors.Errorf("zstdwrap.TrainDictionaryCover: capacity %d less than %d: %w", dictCapacity, C.ZDICT_DICTSIZE_MIN, ErrDstSizeTooSmall)
+	}
+	flat, sizes := flattenSamples(samples)
+	if len(flat) == 0 {
+		return nil, params, errors.New("zstdwrap.TrainDictionaryCover: no samples")
+	}
+	dict := make([]byte, dictCapacity)
+	dictv := unsafe.Pointer(&dict[0])
+	flatv := unsafe.Pointer(&flat[0])
+	optimize := params.K == 0 || params.D == 0
+
+	var res C.size_t
+	if params.Fast {
+		var p C.ZDICT_fastCover_params_t
+		p.k = C.uint(params.K)
+		p.d = C.uint(params.D)
+		p.steps = C.uint(params.Steps)
+		p.nbThreads = 1
+		p.zParams.compressionLevel = C.int(params.CompressionLevel)
+		if optimize {
+			res = C.ZDICT_optimizeTrainFromBuffer_fastCover(dictv, C.size_t(len(dict)), flatv, &sizes[0], C.uint(len(sizes)), &p)
+		} else {
+			res = C.ZDICT_trainFromBuffer_fastCover(dictv, C.size_t(len(dict)), flatv, &sizes[0], C.uint(len(sizes)), p)
+		}
+		params.K, params.D, params.Steps = int(p.k), int(p.d), int(p.steps)
+	} else {
+		var p C.ZDICT_cover_params_t
+		p.k = C.uint(params.K)
+		p.d = C.uint(params.D)
+		p.steps = C.uint(params.Steps)
+		p.nbThreads = 1
+		p.zParams.compressionLevel = C.int(params.CompressionLevel)
+		if optimize {
+			res = C.ZDICT_optimizeTrainFromBuffer_cover(dictv, C.size_t(len(dict)), flatv, &sizes[0], C.uint(len(sizes)), &p)
+		} else {
+			res = C.ZDICT_trainFromBuffer_cover(dictv, C.size_t(len(dict)), flatv, &sizes[0], C.uint(len(sizes)), p)
+		}
+		params.K, params.D, params.Steps = int(p.k), int(p.d), int(p.steps)
+	}
+	if err := isDictErr("TrainDictionaryCover", res); err != nil {
+		return nil, params, err
+	}
+	return dict[:int(res)], params, nil
+}
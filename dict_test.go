@@ -0,0 +1,596 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+// samples generates n small JSON records for dictionary training.
+func samples(n int, seed int64) [][]byte {
+	rnd := rand.New(rand.NewSource(seed))
+	cities := []string{"Springfield", "Shelbyville", "Ogdenville", "North Haverbrook", "Capital City"}
+	out := make([][]byte, n)
+	for i := range out {
+		out[i] = []byte(fmt.Sprintf(`{"id":%d,"name":"user%d","email":"user%d@example.com","address":{"street":"%d Main St","city":%q,"country":"US"},"active":%v,"score":%d}`,
+			i, rnd.Intn(1e6), rnd.Intn(1e6), rnd.Intn(1000), cities[rnd.Intn(len(cities))], rnd.Intn(2) == 0, rnd.Intn(100)))
+	}
+	return out
+}
+
+func TestTrainDictionary(t *testing.T) {
+	dict, err := zstdwrap.TrainDictionary(4096, samples(2000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dict) == 0 || len(dict) > 4096 {
+		t.Fatalf("len(dict)=%d, want (0, 4096]", len(dict))
+	}
+
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+		Dictionary: dict,
+		DictIDFlag: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.LoadDictionary(dict); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range samples(3, 2) {
+		compressed, err := c.Compress(nil, rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.Decompress(nil, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(rec) {
+			t.Errorf("round trip mismatch: %q", got)
+		}
+	}
+
+	t.Run("GetDictIDFromFrame", func(t *testing.T) {
+		compressed, err := c.Compress(nil, samples(1, 4)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := zstdwrap.GetDictIDFromFrame(compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id == 0 {
+			t.Error("frame does not record dictionary ID")
+		}
+
+		plain, err := zstdwrap.NewCompressor(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer plain.Delete()
+		compressed, err = plain.Compress(nil, samples(1, 4)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := zstdwrap.GetDictIDFromFrame(compressed); err != nil {
+			t.Fatal(err)
+		} else if id != 0 {
+			t.Errorf("GetDictIDFromFrame(no dictionary)=%d, want 0", id)
+		}
+		if _, err := zstdwrap.GetDictIDFromFrame([]byte("not a frame")); err != zstdwrap.ErrBadFrame {
+			t.Errorf("GetDictIDFromFrame(garbage) err=%v, want ErrBadFrame", err)
+		}
+	})
+
+	t.Run("DictionaryWrong", func(t *testing.T) {
+		other, err := zstdwrap.TrainDictionary(4096, samples(2000, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := zstdwrap.NewDecompressor(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Delete()
+		if err := d.LoadDictionary(other); err != nil {
+			t.Fatal(err)
+		}
+		compressed, err := c.Compress(nil, samples(1, 4)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Decompress(nil, compressed); !xerrors.Is(err, zstdwrap.ErrDictionaryWrong) {
+			t.Errorf("Decompress with wrong dictionary err=%v, want ErrDictionaryWrong", err)
+		}
+	})
+
+	t.Run("CapacityTooSmall", func(t *testing.T) {
+		_, err := zstdwrap.TrainDictionary(16, samples(2000, 1))
+		if !xerrors.Is(err, zstdwrap.ErrDstSizeTooSmall) {
+			t.Errorf("TrainDictionary err=%v, want ErrDstSizeTooSmall", err)
+		}
+	})
+}
+
+func TestTrainDictionaryCover(t *testing.T) {
+	for _, fast := range []bool{false, true} {
+		fast := fast
+		t.Run(fmt.Sprintf("Fast=%v", fast), func(t *testing.T) {
+			train := samples(1000, 1)
+			dict, params, err := zstdwrap.TrainDictionaryCover(4096, train, zstdwrap.CoverParams{
+				Steps: 4,
+				Fast:  fast,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dict) == 0 {
+				t.Fatal("empty dictionary")
+			}
+			if params.K == 0 || params.D == 0 {
+				t.Fatalf("optimizer did not report parameters: %+v", params)
+			}
+
+			// Reuse the chosen parameters without searching.
+			dict2, params2, err := zstdwrap.TrainDictionaryCover(4096, train, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params2.K != params.K || params2.D != params.D {
+				t.Errorf("parameters changed: %+v, want %+v", params2, params)
+			}
+
+			c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Dictionary: dict2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Delete()
+			d, err := zstdwrap.NewDecompressor(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Delete()
+			if err := d.LoadDictionary(dict2); err != nil {
+				t.Fatal(err)
+			}
+			rec := samples(1, 2)[0]
+			compressed, err := c.Compress(nil, rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := d.Decompress(nil, compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(rec) {
+				t.Errorf("round trip mismatch: %q", got)
+			}
+		})
+	}
+}
+
+func TestDictionaryErrors(t *testing.T) {
+	// Dictionary magic number followed by garbage entropy tables.
+	garbage := append([]byte{0x37, 0xa4, 0x30, 0xec}, bytes.Repeat([]byte{0xff}, 1000)...)
+
+	t.Run("Corrupted", func(t *testing.T) {
+		if _, err := zstdwrap.NewCDict(garbage, 3); !xerrors.Is(err, zstdwrap.ErrDictionaryCorrupted) {
+			t.Errorf("NewCDict err=%v, want ErrDictionaryCorrupted", err)
+		}
+		if _, err := zstdwrap.NewDDict(garbage); !xerrors.Is(err, zstdwrap.ErrDictionaryCorrupted) {
+			t.Errorf("NewDDict err=%v, want ErrDictionaryCorrupted", err)
+		}
+		if _, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Dictionary: garbage}); !xerrors.Is(err, zstdwrap.ErrDictionaryCorrupted) {
+			t.Errorf("NewCompressor err=%v, want ErrDictionaryCorrupted", err)
+		}
+		d, err := zstdwrap.NewDecompressor(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Delete()
+		if err := d.LoadDictionary(garbage); !xerrors.Is(err, zstdwrap.ErrDictionaryCorrupted) {
+			t.Errorf("LoadDictionary err=%v, want ErrDictionaryCorrupted", err)
+		}
+	})
+
+	t.Run("Wrong", func(t *testing.T) {
+		dict1, err := zstdwrap.TrainDictionary(4096, samples(1000, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dict2, err := zstdwrap.TrainDictionary(2048, samples(1000, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Dictionary: dict1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Delete()
+		compressed, err := c.Compress(nil, samples(1, 3)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := zstdwrap.NewDecompressor(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Delete()
+		if err := d.LoadDictionary(dict2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Decompress(nil, compressed); !xerrors.Is(err, zstdwrap.ErrDictionaryWrong) {
+			t.Errorf("Decompress err=%v, want ErrDictionaryWrong", err)
+		}
+	})
+
+	t.Run("CreationFailed", func(t *testing.T) {
+		tiny := [][]byte{[]byte("a"), []byte("b")}
+		if _, err := zstdwrap.TrainDictionary(1024, tiny); !xerrors.Is(err, zstdwrap.ErrDictionaryCreationFailed) {
+			t.Errorf("TrainDictionary err=%v, want ErrDictionaryCreationFailed", err)
+		}
+		if _, _, err := zstdwrap.TrainDictionaryCover(1024, tiny, zstdwrap.CoverParams{}); !xerrors.Is(err, zstdwrap.ErrDictionaryCreationFailed) {
+			t.Errorf("TrainDictionaryCover err=%v, want ErrDictionaryCreationFailed", err)
+		}
+	})
+}
+
+func TestDictionaryByReference(t *testing.T) {
+	dict, err := zstdwrap.TrainDictionary(16<<10, samples(4000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := samples(1, 2)[0]
+
+	compress := func(byRef bool) ([]byte, int) {
+		t.Helper()
+		c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+			Dictionary:            dict,
+			DictionaryByReference: byRef,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Delete()
+		compressed, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return compressed, c.SizeOf()
+	}
+	byCopy, copySize := compress(false)
+	byRef, refSize := compress(true)
+	if !bytes.Equal(byCopy, byRef) {
+		t.Error("compressed output differs by reference")
+	}
+	if refSize+len(dict) > copySize {
+		t.Errorf("SizeOf by reference %d, by copy %d, want a difference of at least %d", refSize, copySize, len(dict))
+	}
+
+	// zstd references a C copy, not the caller's slice.
+	mut := append([]byte{}, dict...)
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+		Dictionary:            mut,
+		DictionaryByReference: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	for i := range mut {
+		mut[i] = 0
+	}
+	if got, err := c.Compress(nil, src); err != nil || !bytes.Equal(got, byCopy) {
+		t.Errorf("output changed after modifying the dictionary slice: %v", err)
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.LoadDictionary(dict); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decompress(nil, byRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Error("round trip mismatch")
+	}
+}
+
+func TestLoadDictionaryAdvanced(t *testing.T) {
+	trained, err := zstdwrap.TrainDictionary(16<<10, samples(4000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Raw content that starts with the dictionary magic number
+	// is misdetected as a structured dictionary.
+	raw := append(append([]byte{}, trained[:4]...), bytes.Join(samples(50, 3), nil)...)
+	src := samples(1, 2)[0]
+
+	// load calls fn with a copy of dict, then clobbers the copy.
+	// Neither load method may keep referencing the caller's bytes.
+	load := func(dict []byte, fn func([]byte) error) {
+		t.Helper()
+		mut := append([]byte{}, dict...)
+		if err := fn(mut); err != nil {
+			t.Fatal(err)
+		}
+		for i := range mut {
+			mut[i] = 0
+		}
+	}
+	roundTrip := func(dict []byte, ct zstdwrap.DictContentType, lm zstdwrap.DictLoadMethod) {
+		t.Helper()
+		c, err := zstdwrap.NewCompressor(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Delete()
+		load(dict, func(dict []byte) error { return c.LoadDictionaryAdvanced(dict, ct, lm) })
+		compressed, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := zstdwrap.Compress(nil, src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(compressed) >= len(plain) {
+			t.Errorf("ct=%d: dictionary did not help: len=%d, without dictionary len=%d", ct, len(compressed), len(plain))
+		}
+
+		d, err := zstdwrap.NewDecompressor(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Delete()
+		load(dict, func(dict []byte) error { return d.LoadDictionaryAdvanced(dict, ct, lm) })
+		got, err := d.Decompress(nil, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("ct=%d: round trip mismatch", ct)
+		}
+	}
+	for _, lm := range []zstdwrap.DictLoadMethod{zstdwrap.DictLoadByCopy, zstdwrap.DictLoadByRef} {
+		roundTrip(raw, zstdwrap.DictContentRaw, lm)
+		roundTrip(trained, zstdwrap.DictContentFull, lm)
+		roundTrip(trained, zstdwrap.DictContentAuto, lm)
+	}
+
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	if err := c.LoadDictionaryAdvanced(raw, zstdwrap.DictContentAuto, zstdwrap.DictLoadByCopy); !xerrors.Is(err, zstdwrap.ErrDictionaryCorrupted) {
+		t.Errorf("Compressor auto-detected raw content: err=%v, want ErrDictionaryCorrupted", err)
+	}
+	if err := d.LoadDictionaryAdvanced(raw, zstdwrap.DictContentAuto, zstdwrap.DictLoadByCopy); !xerrors.Is(err, zstdwrap.ErrDictionaryCorrupted) {
+		t.Errorf("Decompressor auto-detected raw content: err=%v, want ErrDictionaryCorrupted", err)
+	}
+	text := bytes.Join(samples(50, 3), nil)
+	if err := c.LoadDictionaryAdvanced(text, zstdwrap.DictContentFull, zstdwrap.DictLoadByCopy); !xerrors.Is(err, zstdwrap.ErrDictionaryWrong) {
+		t.Errorf("Compressor full dictionary from text: err=%v, want ErrDictionaryWrong", err)
+	}
+	if err := d.LoadDictionaryAdvanced(text, zstdwrap.DictContentFull, zstdwrap.DictLoadByCopy); !xerrors.Is(err, zstdwrap.ErrDictionaryWrong) {
+		t.Errorf("Decompressor full dictionary from text: err=%v, want ErrDictionaryWrong", err)
+	}
+}
+
+func TestForceDictionary(t *testing.T) {
+	dict, err := zstdwrap.TrainDictionary(16<<10, samples(4000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same dictionary content under another ID, as
+	// written by a legacy encoder that mislabeled its frames.
+	relabeled := append([]byte{}, dict...)
+	relabeled[4] ^= 0xff
+	srcs := samples(3, 2)
+
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+		Dictionary: relabeled,
+		DictIDFlag: true,
+		Checksum:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	var frames [][]byte
+	for _, src := range srcs {
+		frame, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.LoadDictionary(dict); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Decompress(nil, frames[0]); !xerrors.Is(err, zstdwrap.ErrDictionaryWrong) {
+		t.Fatalf("Decompress err=%v, want ErrDictionaryWrong", err)
+	}
+
+	d.SetForceDictionary(true)
+	for i, frame := range frames {
+		got, err := d.Decompress(nil, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, srcs[i]) {
+			t.Errorf("frame %d: round trip mismatch", i)
+		}
+	}
+	stream := bytes.Join(frames, nil)
+	buf := make([]byte, 0, 4096)
+	n, err := d.DecompressInto(buf, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], bytes.Join(srcs, nil)) {
+		t.Error("DecompressInto round trip mismatch")
+	}
+
+	d.SetIgnoreChecksum(true)
+	if got, err := d.Decompress(nil, frames[1]); err != nil || !bytes.Equal(got, srcs[1]) {
+		t.Errorf("with SetIgnoreChecksum: %v", err)
+	}
+
+	d.SetForceDictionary(false)
+	if _, err := d.Decompress(nil, frames[0]); !xerrors.Is(err, zstdwrap.ErrDictionaryWrong) {
+		t.Errorf("Decompress err=%v, want ErrDictionaryWrong", err)
+	}
+}
+
+func TestGetDictIDFromDict(t *testing.T) {
+	dict, err := zstdwrap.TrainDictionary(4096, samples(2000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := zstdwrap.GetDictIDFromDict(dict)
+	if id == 0 {
+		t.Fatal("trained dictionary has no ID")
+	}
+
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+		Dictionary: dict,
+		DictIDFlag: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	frame, err := c.Compress(nil, samples(1, 2)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := zstdwrap.GetDictIDFromFrame(frame); err != nil || got != id {
+		t.Errorf("GetDictIDFromFrame=%d, %v, want %d", got, err, id)
+	}
+
+	for _, raw := range [][]byte{nil, []byte("raw content dictionary")} {
+		if got := zstdwrap.GetDictIDFromDict(raw); got != 0 {
+			t.Errorf("GetDictIDFromDict(%q)=%d, want 0", raw, got)
+		}
+	}
+}
+
+func TestValidateDictionary(t *testing.T) {
+	dict, err := zstdwrap.TrainDictionary(4096, samples(2000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := zstdwrap.ValidateDictionary(dict); err != nil {
+		t.Errorf("trained dictionary: %v", err)
+	}
+	if err := zstdwrap.ValidateDictionary([]byte("raw content dictionary")); err != nil {
+		t.Errorf("raw content: %v", err)
+	}
+
+	// Without the magic number, any bytes are raw content.
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	random[0] = 0
+	if err := zstdwrap.ValidateDictionary(random); err != nil {
+		t.Errorf("random raw content: %v", err)
+	}
+
+	magic := []byte{0x37, 0xa4, 0x30, 0xec}
+	for name, bad := range map[string][]byte{
+		"garbage tables": append(magic, random...),
+		"truncated":      dict[:100],
+		"no ID":          append(append(append([]byte{}, magic...), 0, 0, 0, 0), dict[8:]...),
+	} {
+		if err := zstdwrap.ValidateDictionary(bad); !xerrors.Is(err, zstdwrap.ErrDictionaryCorrupted) {
+			t.Errorf("%s: err=%v, want ErrDictionaryCorrupted", name, err)
+		}
+	}
+	if err := zstdwrap.ValidateDictionary(nil); err == nil {
+		t.Error("empty dictionary is valid")
+	}
+}
+
+func BenchmarkDictionarySmall(b *testing.B) {
+	dict, err := zstdwrap.TrainDictionary(16<<10, samples(4000, 1))
+	if err != nil {
+		b.Fatal(err)
+	}
+	srcs := samples(100, 2)
+	cd, err := zstdwrap.NewCDict(dict, 3)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cd.Delete()
+
+	for _, bench := range []struct {
+		name string
+		opts *zstdwrap.COptions
+	}{
+		{"Dictionary", &zstdwrap.COptions{Dictionary: dict, CompressionLevel: 3}},
+		{"CDict", &zstdwrap.COptions{CDict: cd}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var dst []byte
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// A fresh Compressor per batch of small inputs,
+				// as when each request takes one from a pool.
+				c, err := zstdwrap.NewCompressor(bench.opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, src := range srcs {
+					if dst, err = c.Compress(dst, src); err != nil {
+						b.Fatal(err)
+					}
+				}
+				c.Delete()
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #include "zstd.h"
+import "C"
+
+// EstimateCCtxSize reports the memory, in bytes, a Compressor
+// needs to Compress at any level up to level.
+// Equivalent to ZSTD_estimateCCtxSize.
+//
+// It does not include memory used by NBWorkers.
+func EstimateCCtxSize(level int) int {
+	return int(C.ZSTD_estimateCCtxSize(C.int(level)))
+}
+
+// EstimateCStreamSize reports the memory, in bytes, a Writer
+// needs to compress at any level up to level.
+// Equivalent to ZSTD_estimateCStreamSize.
+func EstimateCStreamSize(level int) int {
+	return int(C.ZSTD_estimateCStreamSize(C.int(level)))
+}
+
+// EstimateWorkers reports the number of workers NewCompressor
+// uses for opts: NBWorkers, reduced until their estimated memory
+// fits in MaxMemory.
+//
+// Each worker is estimated to need a context for the compression
+// level, as by EstimateCCtxSize, plus an input and an output
+// buffer for one job. Jobs default to four times the window size,
+// at least 1mb. The estimate is rough: it leaves out the overlap
+// with the previous job and the memory of the Compressor itself.
+func EstimateWorkers(opts *COptions) int {
+	if opts == nil || opts.NBWorkers <= 0 {
+		return 0
+	}
+	if opts.MaxMemory <= 0 {
+		return opts.NBWorkers
+	}
+	level := opts.CompressionLevel
+	if level == 0 {
+		level = DefaultCompressionLevel()
+	}
+	windowLog := opts.WindowLog
+	if windowLog == 0 {
+		windowLog = int(C.ZSTD_getCParams(C.int(level), 0, 0).windowLog)
+	}
+	jobSize := int64(opts.JobSize)
+	if jobSize == 0 {
+		jobSize = 1 << uint(windowLog+2)
+	}
+	if jobSize < 1<<20 {
+		jobSize = 1 << 20 // ZSTDMT_JOBSIZE_MIN
+	}
+	perWorker := int64(EstimateCCtxSize(level)) + jobSize + int64(CompressBound(int(jobSize)))
+	if n := opts.MaxMemory / perWorker; n < int64(opts.NBWorkers) {
+		return int(n)
+	}
+	return opts.NBWorkers
+}
+
+// EstimateDCtxSize reports the memory, in bytes,
+// a Decompressor needs to Decompress.
+// Equivalent to ZSTD_estimateDCtxSize.
+func EstimateDCtxSize() int {
+	return int(C.ZSTD_estimateDCtxSize())
+}
+
+// EstimateDStreamSize reports the memory, in bytes, a Reader
+// needs to decompress frames with windows up to windowSize bytes.
+// Equivalent to ZSTD_estimateDStreamSize.
+func EstimateDStreamSize(windowSize int) int {
+	return int(C.ZSTD_estimateDStreamSize(C.size_t(windowSize)))
+}
+
+// SizeOf reports the memory, in bytes, currently used by
+// the Compressor. Equivalent to ZSTD_sizeof_CCtx.
+func (c *Compressor) SizeOf() int {
+	return int(C.ZSTD_sizeof_CCtx(c.ctx))
+}
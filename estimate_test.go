@@ -0,0 +1,85 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestEstimate(t *testing.T) {
+	if lo, hi := zstdwrap.EstimateCCtxSize(1), zstdwrap.EstimateCCtxSize(19); lo <= 0 || hi <= lo {
+		t.Errorf("EstimateCCtxSize(1)=%d, EstimateCCtxSize(19)=%d, want 0 < lo < hi", lo, hi)
+	}
+	if lo, hi := zstdwrap.EstimateCStreamSize(1), zstdwrap.EstimateCStreamSize(19); lo <= 0 || hi <= lo {
+		t.Errorf("EstimateCStreamSize(1)=%d, EstimateCStreamSize(19)=%d, want 0 < lo < hi", lo, hi)
+	}
+	if n := zstdwrap.EstimateDCtxSize(); n <= 0 {
+		t.Errorf("EstimateDCtxSize()=%d, want > 0", n)
+	}
+	if lo, hi := zstdwrap.EstimateDStreamSize(1<<10), zstdwrap.EstimateDStreamSize(1<<20); lo <= 0 || hi <= lo {
+		t.Errorf("EstimateDStreamSize(1kb)=%d, EstimateDStreamSize(1mb)=%d, want 0 < lo < hi", lo, hi)
+	}
+
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CompressionLevel: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	before := c.SizeOf()
+	if _, err := c.Compress(nil, []byte(strings.Repeat("hello, world. ", 10000))); err != nil {
+		t.Fatal(err)
+	}
+	after := c.SizeOf()
+	if before <= 0 || after < before {
+		t.Errorf("SizeOf before Compress %d, after %d", before, after)
+	}
+	if est := zstdwrap.EstimateCCtxSize(5); after > est {
+		t.Errorf("SizeOf()=%d, exceeds EstimateCCtxSize(5)=%d", after, est)
+	}
+}
+
+func TestEstimateWorkers(t *testing.T) {
+	opts := &zstdwrap.COptions{NBWorkers: 8, JobSize: 4 << 20}
+	if n := zstdwrap.EstimateWorkers(opts); n != 8 {
+		t.Errorf("no MaxMemory: EstimateWorkers=%d, want 8", n)
+	}
+	// Each worker holds at least an input and an output job buffer.
+	opts.MaxMemory = 3 * 2 * int64(opts.JobSize)
+	n := zstdwrap.EstimateWorkers(opts)
+	if n <= 0 || n >= 3 {
+		t.Errorf("MaxMemory of 3 jobs: EstimateWorkers=%d, want 1 or 2", n)
+	}
+	c, err := zstdwrap.NewCompressor(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	if got, err := c.GetParameter(zstdwrap.CParamNBWorkers); err != nil || got != n {
+		t.Errorf("NBWorkers=%d, %v, want %d", got, err, n)
+	}
+
+	opts.MaxMemory = 1 << 20
+	if n := zstdwrap.EstimateWorkers(opts); n != 0 {
+		t.Errorf("MaxMemory below one job: EstimateWorkers=%d, want 0", n)
+	}
+	opts.MaxMemory = -1
+	if _, err := zstdwrap.NewCompressor(opts); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+		t.Errorf("negative MaxMemory: err=%v, want ErrParameterOutOfBound", err)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+import "sync/atomic"
+
+var CompressBoundC = compressBoundC
+
+// HeaderParses reports how many frame headers d has parsed
+// to find a content size, missing its cache.
+func HeaderParses(d *Decompressor) int { return d.sizeCache.misses }
+
+// Leaks reports how many contexts FreeOnFinalize finalizers freed.
+func Leaks() int64 { return atomic.LoadInt64(&leaks) }
+
+// StoredFrame is storedFrame, for testing frames larger than
+// Compress would choose to store.
+func StoredFrame(format Format, src []byte) ([]byte, error) {
+	dst := make([]byte, storedSize(format, true, len(src)))
+	n, err := storedFrame("StoredFrame", format, true, dst, src)
+	return dst[:n], err
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build !zstdwrap_debug
+// +build !zstdwrap_debug
+
+package zstdwrap
+
+// useGuard detects concurrent use of a Compressor or Decompressor
+// when built with the zstdwrap_debug tag. Otherwise it is empty.
+type useGuard struct{}
+
+func (useGuard) enter(typ string) {}
+func (useGuard) exit()            {}
+
+// logLeak logs a context freed by a finalizer when built
+// with the zstdwrap_debug tag.
+func logLeak(typ string) {}
@@ -0,0 +1,45 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build zstdwrap_debug
+// +build zstdwrap_debug
+
+package zstdwrap
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// useGuard panics when a Compressor or Decompressor is used by
+// two goroutines at once, instead of corrupting its zstd context.
+type useGuard struct {
+	inUse int32
+}
+
+func (g *useGuard) enter(typ string) {
+	if !atomic.CompareAndSwapInt32(&g.inUse, 0, 1) {
+		panic("zstdwrap: concurrent use of " + typ)
+	}
+}
+
+func (g *useGuard) exit() {
+	atomic.StoreInt32(&g.inUse, 0)
+}
+
+// logLeak reports a Compressor or Decompressor that was garbage
+// collected without Delete, freed by its FreeOnFinalize finalizer.
+func logLeak(typ string) {
+	log.Printf("zstdwrap: %s garbage collected without Delete", typ)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+import "time"
+
+// levelSpeeds is a rough single-threaded compression speed,
+// in MB/s, for levels 1 through 19 on a modern x86-64 core.
+// It is measured on mixed text, so real inputs vary widely.
+var levelSpeeds = [...]float64{
+	1: 500, 2: 400, 3: 330, 4: 300, 5: 170,
+	6: 140, 7: 110, 8: 90, 9: 75, 10: 60,
+	11: 45, 12: 40, 13: 18, 14: 15, 15: 11,
+	16: 7, 17: 5, 18: 4, 19: 3,
+}
+
+// levelSizeCaps is the highest level worth using for inputs up
+// to a size. Small inputs fit in the window of low levels, so
+// higher levels mostly spend time building larger tables.
+var levelSizeCaps = []struct {
+	size  int64
+	level int
+}{
+	{4 << 10, 3},
+	{64 << 10, 9},
+	{1 << 20, 15},
+}
+
+// RecommendedLevel suggests a compression level for srcSize bytes
+// of input that is expected to compress within budget.
+// A budget of zero or less means there is no time limit.
+//
+// It is a heuristic of this package, not provided by zstd: a
+// small table of typical compression speeds and of the level
+// beyond which small inputs stop gaining ratio. The result is
+// between 1 and 19; the ultra levels are never suggested.
+func RecommendedLevel(srcSize int64, budget time.Duration) int {
+	max := len(levelSpeeds) - 1
+	for _, c := range levelSizeCaps {
+		if srcSize <= c.size {
+			max = c.level
+			break
+		}
+	}
+	if budget <= 0 {
+		return max
+	}
+	mb := float64(srcSize) / 1e6
+	for level := max; level > 1; level-- {
+		if time.Duration(mb/levelSpeeds[level]*float64(time.Second)) <= budget {
+			return level
+		}
+	}
+	return 1
+}
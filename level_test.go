@@ -0,0 +1,52 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crawshaw/zstdwrap"
+)
+
+func TestRecommendedLevel(t *testing.T) {
+	for _, test := range []struct {
+		size   int64
+		budget time.Duration
+		want   int
+	}{
+		{0, 0, 3},
+		{1 << 10, time.Hour, 3},
+		{4 << 10, 0, 3},
+		{4<<10 + 1, 0, 9},
+		{64 << 10, time.Hour, 9},
+		{64<<10 + 1, time.Hour, 15},
+		{1 << 20, 0, 15},
+		{1<<20 + 1, 0, 19},
+		{1 << 30, 0, 19},
+
+		// 100MB takes 33s at 3MB/s, 1.7s at 60MB/s, 0.9s at 110MB/s.
+		{100e6, time.Minute, 19},
+		{100e6, 30 * time.Second, 18},
+		{100e6, 2 * time.Second, 10},
+		{100e6, time.Second, 7},
+		{100e6, time.Millisecond, 1},
+		{1<<20 + 1, time.Millisecond, 1},
+	} {
+		if got := zstdwrap.RecommendedLevel(test.size, test.budget); got != test.want {
+			t.Errorf("RecommendedLevel(%d, %v)=%d, want %d", test.size, test.budget, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+import (
+	"runtime"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// DecompressParallel decompresses the concatenated frames in src
+// using up to workers goroutines, and returns their content in
+// order. If workers is zero or less, GOMAXPROCS is used.
+//
+// Frames are split with FrameCompressedSize and each is decoded
+// independently by one of the workers' Decompressors. They use
+// the default window limit of NewDecompressor, so a frame
+// larger than the window cap reports ErrFrameTooBig before it
+// is allocated. Decoded frames are held until they are joined,
+// so peak memory is about twice the total content size.
+//
+// Frames compressed with a dictionary or magicless frames are not
+// supported; decode those with a configured Decompressor.
+func DecompressParallel(src []byte, workers int) ([]byte, error) {
+	sizes, rest, err := FrameSizes(src)
+	if err != nil {
+		return nil, xerrors.Errorf("zstdwrap.DecompressParallel: %w", err)
+	} else if rest > 0 {
+		return nil, xerrors.Errorf("zstdwrap.DecompressParallel: %w", ErrTruncatedFrame)
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(sizes) {
+		workers = len(sizes)
+	}
+
+	frames := make([][]byte, len(sizes))
+	for off, i := 0, 0; i < len(sizes); off, i = off+sizes[i], i+1 {
+		frames[i] = src[off : off+sizes[i]]
+	}
+	outs := make([][]byte, len(frames))
+	errs := make([]error, len(frames))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		d, err := NewDecompressor(0)
+		if err != nil {
+			close(jobs)
+			wg.Wait()
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer d.Delete()
+			for i := range jobs {
+				outs[i], errs[i] = d.Decompress(nil, frames[i])
+			}
+		}()
+	}
+	for i := range frames {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var total int
+	for i, err := range errs {
+		if err != nil {
+			return nil, xerrors.Errorf("zstdwrap.DecompressParallel: frame %d: %w", i, err)
+		}
+		total += len(outs[i])
+	}
+	dst := make([]byte, 0, total)
+	for _, out := range outs {
+		dst = append(dst, out...)
+	}
+	return dst, nil
+}
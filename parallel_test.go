@@ -0,0 +1,79 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestDecompressParallel(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+
+	rnd := rand.New(rand.NewSource(1))
+	var src []byte
+	for i := 0; i < 20; i++ {
+		content := make([]byte, rnd.Intn(200<<10))
+		for j := range content {
+			content[j] = byte('a' + rnd.Intn(4))
+		}
+		if i%5 == 3 {
+			// A frame without a recorded content size.
+			var buf bytes.Buffer
+			w, err := zstdwrap.NewWriter(&buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(content)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			src = append(src, buf.Bytes()...)
+			continue
+		}
+		if src, err = c.CompressAppend(src, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := zstdwrap.Decompress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{0, 1, 3, 64} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			got, err := zstdwrap.DecompressParallel(src, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %d bytes, want %d, parallel and sequential decode differ", len(got), len(want))
+			}
+		})
+	}
+
+	if _, err := zstdwrap.DecompressParallel(src[:len(src)-1], 4); !xerrors.Is(err, zstdwrap.ErrTruncatedFrame) {
+		t.Errorf("truncated: err=%v, want ErrTruncatedFrame", err)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #include "zstd.h"
+import "C"
+import "golang.org/x/xerrors"
+
+// CParameter is a zstd compression parameter.
+// The COptions fields of the same name describe each parameter.
+//
+// The first group of constants are the parameters the bundled
+// zstd considers stable. The second are experimental in it,
+// available only with ZSTD_STATIC_LINKING_ONLY, and their values
+// may change in later zstd releases. SetParameter passes other
+// values through to zstd, which reports ErrParameterUnsupported
+// for those it does not know.
+type CParameter int
+
+const (
+	CParamCompressionLevel           CParameter = C.ZSTD_c_compressionLevel
+	CParamWindowLog                  CParameter = C.ZSTD_c_windowLog
+	CParamHashLog                    CParameter = C.ZSTD_c_hashLog
+	CParamChainLog                   CParameter = C.ZSTD_c_chainLog
+	CParamSearchLog                  CParameter = C.ZSTD_c_searchLog
+	CParamMinMatch                   CParameter = C.ZSTD_c_minMatch
+	CParamTargetLength               CParameter = C.ZSTD_c_targetLength
+	CParamStrategy                   CParameter = C.ZSTD_c_strategy
+	CParamEnableLongDistanceMatching CParameter = C.ZSTD_c_enableLongDistanceMatching
+	CParamLDMHashLog                 CParameter = C.ZSTD_c_ldmHashLog
+	CParamLDMMinMatch                CParameter = C.ZSTD_c_ldmMinMatch
+	CParamLDMBucketSizeLog           CParameter = C.ZSTD_c_ldmBucketSizeLog
+	CParamLDMHashRateLog             CParameter = C.ZSTD_c_ldmHashRateLog
+	CParamContentSizeFlag            CParameter = C.ZSTD_c_contentSizeFlag
+	CParamChecksumFlag               CParameter = C.ZSTD_c_checksumFlag
+	CParamDictIDFlag                 CParameter = C.ZSTD_c_dictIDFlag
+	CParamNBWorkers                  CParameter = C.ZSTD_c_nbWorkers
+	CParamJobSize                    CParameter = C.ZSTD_c_jobSize
+	CParamOverlapLog                 CParameter = C.ZSTD_c_overlapLog
+)
+
+// Experimental compression parameters.
+const (
+	CParamRsyncable              CParameter = C.ZSTD_c_rsyncable
+	CParamFormat                 CParameter = C.ZSTD_c_format
+	CParamLiteralCompressionMode CParameter = C.ZSTD_c_literalCompressionMode
+)
+
+// GetParameter reports the value of p used by the Compressor,
+// with ZSTD_CCtx_getParameter.
+//
+// zstd clamps some parameters, such as the compression level,
+// so the value may differ from the one set. Parameters left at
+// their default, including those derived from the compression
+// level, report zero.
+func (c *Compressor) GetParameter(p CParameter) (int, error) {
+	var value C.int
+	res := C.ZSTD_CCtx_getParameter(c.ctx, C.ZSTD_cParameter(p), &value)
+	if err := isErr("GetParameter", res); err != nil {
+		return 0, err
+	}
+	return int(value), nil
+}
+
+// SetParameter sets p to value with ZSTD_CCtx_setParameter.
+// The new value applies from the next frame, so SetParameter
+// can reconfigure a Compressor or Writer between frames, such
+// as after Writer.EndFrame.
+//
+// Zero restores the default for parameters whose default is
+// zero, including those derived from the compression level.
+// For CParamCompressionLevel zero selects
+// DefaultCompressionLevel, since zstd itself would leave the
+// level unchanged. For the flags zero disables: the content
+// size and dictionary ID flags are on by default, and setting
+// them to zero turns them off.
+//
+// zstd rejects most changes in the middle of a frame with
+// ErrStageWrong. The compression level is an exception: the
+// change may take effect within the current frame.
+//
+// A dictionary loaded with COptions.Dictionary or
+// LoadDictionaryAdvanced is digested with the parameters
+// set when it was loaded.
+func (c *Compressor) SetParameter(p CParameter, value int) error {
+	if p == CParamCompressionLevel {
+		if value == 0 {
+			value = DefaultCompressionLevel()
+		}
+		if err := checkLevel("SetParameter", value); err != nil {
+			return err
+		}
+	}
+	return c.setParameter("SetParameter", p, value)
+}
+
+// SupportsParameter reports whether the bundled zstd knows p.
+// Parameters added by later zstd releases, such as
+// ZSTD_c_targetCBlockSize, are not supported.
+//
+// It probes by setting p to zero with ZSTD_CCtx_setParameter
+// on a throwaway context, so the probe has no effect on any
+// Compressor. Only ErrParameterUnsupported counts as unsupported.
+func SupportsParameter(p CParameter) bool {
+	c, err := NewCompressor(nil)
+	if err != nil {
+		return false
+	}
+	defer c.Delete()
+	err = c.setParameter("SupportsParameter", p, 0)
+	return !xerrors.Is(err, ErrParameterUnsupported)
+}
+
+func (c *Compressor) setParameter(loc string, p CParameter, value int) error {
+	res := C.ZSTD_CCtx_setParameter(c.ctx, C.ZSTD_cParameter(p), C.int(value))
+	if err := isErr(loc, res); err != nil {
+		return err
+	}
+	switch p {
+	case CParamChecksumFlag:
+		c.checksum = value != 0
+	case CParamContentSizeFlag:
+		c.noContentSize = value == 0
+	case CParamFormat:
+		c.format = Format(value)
+	}
+	return nil
+}
+
+// DParameter is a zstd decompression parameter.
+// See CParameter.
+type DParameter int
+
+const (
+	DParamWindowLogMax DParameter = C.ZSTD_d_windowLogMax
+)
+
+// Experimental decompression parameters.
+const (
+	DParamFormat DParameter = C.ZSTD_d_format
+)
+
+// GetParameter reports the value of p used by the Decompressor.
+//
+// The bundled zstd predates ZSTD_DCtx_getParameter, so the value
+// is the one recorded when the parameter was set. A default
+// windowLogMax is reported as ZSTD_WINDOWLOG_LIMIT_DEFAULT.
+func (d *Decompressor) GetParameter(p DParameter) (int, error) {
+	switch p {
+	case DParamWindowLogMax:
+		return d.windowLogMax, nil
+	case DParamFormat:
+		return int(d.format), nil
+	}
+	return 0, xerrors.Errorf("zstdwrap.GetParameter: parameter %d: %w", p, ErrParameterUnsupported)
+}
+
+// SetParameter sets p to value with ZSTD_DCtx_setParameter.
+// Zero restores the default of both parameters: a windowLogMax
+// of ZSTD_WINDOWLOG_LIMIT_DEFAULT and FormatZstd1. Parameters
+// cannot be changed while a Reader is in the middle of a frame.
+func (d *Decompressor) SetParameter(p DParameter, value int) error {
+	return d.setParameter("SetParameter", p, value)
+}
+
+func (d *Decompressor) setParameter(loc string, p DParameter, value int) error {
+	if p == DParamWindowLogMax && value == 0 {
+		// The bundled zstd rejects zero rather than
+		// restoring the default.
+		value = C.ZSTD_WINDOWLOG_LIMIT_DEFAULT
+	}
+	res := C.ZSTD_DCtx_setParameter(d.ctx, C.ZSTD_dParameter(p), C.int(value))
+	if err := isErr(loc, res); err != nil {
+		return err
+	}
+	switch p {
+	case DParamWindowLogMax:
+		d.windowLogMax = value
+	case DParamFormat:
+		d.format = Format(value)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestCompressorGetParameter(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+		CompressionLevel: 7,
+		WindowLog:        20,
+		Checksum:         true,
+
+		LiteralCompressionMode: zstdwrap.LiteralUncompressed,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+
+	for _, p := range []struct {
+		name  string
+		param zstdwrap.CParameter
+		want  int
+	}{
+		{"CompressionLevel", zstdwrap.CParamCompressionLevel, 7},
+		{"WindowLog", zstdwrap.CParamWindowLog, 20},
+		{"ChecksumFlag", zstdwrap.CParamChecksumFlag, 1},
+		{"HashLog", zstdwrap.CParamHashLog, 0},
+		{"LiteralCompressionMode", zstdwrap.CParamLiteralCompressionMode, int(zstdwrap.LiteralUncompressed)},
+	} {
+		got, err := c.GetParameter(p.param)
+		if err != nil {
+			t.Errorf("%s: %v", p.name, err)
+		} else if got != p.want {
+			t.Errorf("%s=%d, want %d", p.name, got, p.want)
+		}
+	}
+}
+
+func TestCompressorSetParameter(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+
+	if err := c.SetParameter(zstdwrap.CParamCompressionLevel, 12); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetParameter(zstdwrap.CParamCompressionLevel); err != nil || got != 12 {
+		t.Errorf("CompressionLevel=%d, %v, want 12", got, err)
+	}
+	if err := c.SetParameter(zstdwrap.CParamCompressionLevel, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetParameter(zstdwrap.CParamCompressionLevel); err != nil || got != zstdwrap.DefaultCompressionLevel() {
+		t.Errorf("CompressionLevel after setting zero=%d, %v, want the default %d", got, err, zstdwrap.DefaultCompressionLevel())
+	}
+
+	// Zero turns a flag off, even one that is on by default.
+	if err := c.SetParameter(zstdwrap.CParamContentSizeFlag, 0); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := c.Compress(nil, []byte("flag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr, err := zstdwrap.ReadFrameHeader(frame); err != nil || hdr.HasContentSize {
+		t.Errorf("ContentSizeFlag 0: header %+v, %v, want no content size", hdr, err)
+	}
+
+	err = c.SetParameter(zstdwrap.CParamCompressionLevel, zstdwrap.MaxCompressionLevel()+1)
+	if !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+		t.Errorf("level out of range: err=%v, want ErrParameterOutOfBound", err)
+	}
+	err = c.SetParameter(zstdwrap.CParamWindowLog, 100)
+	if !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+		t.Errorf("WindowLog=100: err=%v, want ErrParameterOutOfBound", err)
+	}
+}
+
+func TestSupportsParameter(t *testing.T) {
+	for _, p := range []zstdwrap.CParameter{
+		zstdwrap.CParamCompressionLevel,
+		zstdwrap.CParamWindowLog,
+		zstdwrap.CParamNBWorkers,
+		zstdwrap.CParamFormat,
+		zstdwrap.CParamLiteralCompressionMode,
+	} {
+		if !zstdwrap.SupportsParameter(p) {
+			t.Errorf("SupportsParameter(%d)=false, want true", p)
+		}
+	}
+	// ZSTD_c_targetCBlockSize in later zstd releases.
+	const targetCBlockSize = zstdwrap.CParameter(1003)
+	if zstdwrap.SupportsParameter(targetCBlockSize) {
+		t.Error("SupportsParameter(targetCBlockSize)=true, want false")
+	}
+}
+
+func TestDecompressorGetParameter(t *testing.T) {
+	for _, windowLogMax := range []int{0, 20, 30} {
+		d, err := zstdwrap.NewDecompressor(windowLogMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := windowLogMax
+		if want == 0 {
+			want = zstdwrap.WindowLogLimitDefault()
+		}
+		if got, err := d.GetParameter(zstdwrap.DParamWindowLogMax); err != nil {
+			t.Error(err)
+		} else if got != want {
+			t.Errorf("NewDecompressor(%d) windowLogMax=%d, want %d", windowLogMax, got, want)
+		}
+		d.Delete()
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.SetFormat(zstdwrap.FormatZstd1Magicless); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.GetParameter(zstdwrap.DParamFormat); err != nil {
+		t.Error(err)
+	} else if got != int(zstdwrap.FormatZstd1Magicless) {
+		t.Errorf("Format=%d, want %d", got, zstdwrap.FormatZstd1Magicless)
+	}
+	if _, err := d.GetParameter(-1); !xerrors.Is(err, zstdwrap.ErrParameterUnsupported) {
+		t.Errorf("GetParameter(-1) err=%v, want ErrParameterUnsupported", err)
+	}
+}
+
+func TestDecompressorSetParameter(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{WindowLog: 23})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	w := bytes.Repeat([]byte("window"), 3<<20)
+	frame, err := c.Compress(nil, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.SetParameter(zstdwrap.DParamWindowLogMax, 20); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := d.GetParameter(zstdwrap.DParamWindowLogMax); got != 20 {
+		t.Errorf("windowLogMax=%d, want 20", got)
+	}
+	if _, err := d.Decompress(nil, frame); err == nil {
+		t.Error("Decompress succeeded with windowLogMax 20")
+	}
+	if err := d.SetParameter(zstdwrap.DParamWindowLogMax, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.Decompress(nil, frame); err != nil || !bytes.Equal(got, w) {
+		t.Errorf("Decompress after restoring default windowLogMax: %v", err)
+	}
+
+	if err := d.SetParameter(zstdwrap.DParamFormat, int(zstdwrap.FormatZstd1Magicless)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetParameter(zstdwrap.DParamFormat, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := d.GetParameter(zstdwrap.DParamFormat); got != int(zstdwrap.FormatZstd1) {
+		t.Errorf("Format after setting zero=%d, want FormatZstd1", got)
+	}
+	if _, err := d.Decompress(nil, frame); err != nil {
+		t.Errorf("Decompress after restoring default format: %v", err)
+	}
+
+	if err := d.SetParameter(-1, 1); !xerrors.Is(err, zstdwrap.ErrParameterUnsupported) {
+		t.Errorf("Decompressor.SetParameter(-1) err=%v, want ErrParameterUnsupported", err)
+	}
+	if err := c.SetParameter(-1, 1); !xerrors.Is(err, zstdwrap.ErrParameterUnsupported) {
+		t.Errorf("Compressor.SetParameter(-1) err=%v, want ErrParameterUnsupported", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #include "zstd.h"
+import "C"
+import (
+	"runtime"
+	"sync"
+)
+
+// Pooled contexts are freed by a finalizer when
+// sync.Pool drops them.
+var (
+	compressors   sync.Pool // of *Compressor
+	decompressors sync.Pool // of *Decompressor
+)
+
+// Compress compresses src into dst at the given compression
+// level, and returns the new dst.
+//
+// Compress uses a pooled Compressor and is safe to call from
+// multiple goroutines. A level of zero is the zstd default.
+func Compress(dst, src []byte, level int) ([]byte, error) {
+	if err := checkLevel("Compress", level); err != nil {
+		return nil, err
+	}
+	c, _ := compressors.Get().(*Compressor)
+	if c == nil {
+		var err error
+		if c, err = NewCompressor(nil); err != nil {
+			return nil, err
+		}
+		runtime.SetFinalizer(c, (*Compressor).Delete)
+	}
+	defer compressors.Put(c)
+	if level == 0 {
+		// zstd treats a level of zero as "unchanged",
+		// which would keep the level of the last caller.
+		level = DefaultCompressionLevel()
+	}
+	res := C.ZSTD_CCtx_setParameter(c.ctx, C.ZSTD_c_compressionLevel, C.int(level))
+	if err := isErr("Compress(level)", res); err != nil {
+		return nil, err
+	}
+	return c.Compress(dst, src)
+}
+
+// CompressCopy compresses src into a newly allocated frame
+// with opts. The result shares no memory with src or any
+// buffer passed to this package, so it can be handed to another
+// goroutine while the caller reuses its slices.
+func CompressCopy(src []byte, opts *COptions) ([]byte, error) {
+	c, err := NewCompressor(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Delete()
+	frame, err := c.Compress(nil, src)
+	if err != nil {
+		return nil, err
+	}
+	return frame[:len(frame):len(frame)], nil
+}
+
+// Decompress decompresses src into dst, and returns the new dst.
+//
+// Decompress uses a pooled Decompressor with the default window
+// limit and is safe to call from multiple goroutines.
+// See Decompressor.Decompress.
+func Decompress(dst, src []byte) ([]byte, error) {
+	d, _ := decompressors.Get().(*Decompressor)
+	if d == nil {
+		var err error
+		if d, err = NewDecompressor(0); err != nil {
+			return nil, err
+		}
+		runtime.SetFinalizer(d, (*Decompressor).Delete)
+	}
+	defer decompressors.Put(d)
+	return d.Decompress(dst, src)
+}
+
+// CompressorPool is a set of Compressors configured with the
+// same COptions that can be shared by multiple goroutines.
+//
+// Compressors taken from the pool with Get must be returned
+// with Put, and never released with Delete. Compressors that
+// the pool discards are released by a finalizer.
+type CompressorPool struct {
+	opts *COptions
+	pool sync.Pool
+}
+
+// NewCompressorPool creates a CompressorPool whose
+// Compressors are created with opts.
+//
+// The opts are checked by creating the first Compressor.
+// They must not be modified while the pool is in use.
+func NewCompressorPool(opts *COptions) (*CompressorPool, error) {
+	p := &CompressorPool{opts: opts}
+	c, err := p.newCompressor()
+	if err != nil {
+		return nil, err
+	}
+	p.pool.Put(c)
+	return p, nil
+}
+
+func (p *CompressorPool) newCompressor() (*Compressor, error) {
+	c, err := NewCompressor(p.opts)
+	if err != nil {
+		return nil, err
+	}
+	// Replace any FreeOnFinalize finalizer: a Compressor the
+	// pool discards is not a leak. Setting a second finalizer
+	// without clearing the first is a fatal error.
+	runtime.SetFinalizer(c, nil)
+	runtime.SetFinalizer(c, (*Compressor).Delete)
+	return c, nil
+}
+
+// Get takes a Compressor from the pool, creating one if necessary.
+//
+// Get panics if zstd cannot allocate a new Compressor.
+func (p *CompressorPool) Get() *Compressor {
+	if c, _ := p.pool.Get().(*Compressor); c != nil {
+		return c
+	}
+	c, err := p.newCompressor()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Put returns c to the pool.
+//
+// The session is reset with ZSTD_CCtx_reset, so a partially
+// compressed frame is discarded. Parameters are kept, so c
+// must not be reconfigured while out of the pool.
+func (p *CompressorPool) Put(c *Compressor) {
+	if err := c.Reset(ResetSessionOnly); err != nil {
+		return // leave c to the finalizer
+	}
+	p.pool.Put(c)
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	var wg sync.WaitGroup
+	errc := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src := strings.Repeat(fmt.Sprintf("goroutine %d;", i), 1000)
+			for level := 1; level < 5; level++ {
+				compressed, err := zstdwrap.Compress(nil, []byte(src), level)
+				if err != nil {
+					errc <- err
+					return
+				}
+				got, err := zstdwrap.Decompress(nil, compressed)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if string(got) != src {
+					errc <- fmt.Errorf("goroutine %d level %d: round trip mismatch", i, level)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+}
+
+func TestCompressLevelZero(t *testing.T) {
+	src := []byte(strings.Repeat("level zero is the default level; ", 2000))
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	want, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strong, err := zstdwrap.Compress(nil, src, 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(strong) == string(want) {
+		t.Fatal("levels 19 and default compress src the same, test is ineffective")
+	}
+
+	// Alternate levels so a pooled Compressor last used at
+	// level 19 is reused with level zero.
+	for i := 0; i < 10; i++ {
+		if _, err := zstdwrap.Compress(nil, src, 19); err != nil {
+			t.Fatal(err)
+		}
+		got, err := zstdwrap.Compress(nil, src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("run %d: Compress level 0 gave %d bytes, want the default level's %d", i, len(got), len(want))
+		}
+	}
+}
+
+func TestCompressorPool(t *testing.T) {
+	pool, err := zstdwrap.NewCompressorPool(&zstdwrap.COptions{
+		CompressionLevel: 3,
+		Checksum:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d, err := zstdwrap.NewDecompressor(0)
+			if err != nil {
+				errc <- err
+				return
+			}
+			defer d.Delete()
+			for j := 0; j < 20; j++ {
+				src := strings.Repeat(fmt.Sprintf("goroutine %d, iteration %d;", i, j), 100)
+				c := pool.Get()
+				compressed, err := c.Compress(nil, []byte(src))
+				pool.Put(c)
+				if err != nil {
+					errc <- err
+					return
+				}
+				hdr, err := zstdwrap.ReadFrameHeader(compressed)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if !hdr.Checksum {
+					errc <- fmt.Errorf("goroutine %d: pooled Compressor lost Checksum option", i)
+					return
+				}
+				got, err := d.Decompress(nil, compressed)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if string(got) != src {
+					errc <- fmt.Errorf("goroutine %d: round trip mismatch", i)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+
+	if _, err := zstdwrap.NewCompressorPool(&zstdwrap.COptions{WindowLog: 100}); err == nil {
+		t.Error("NewCompressorPool with bad WindowLog succeeded")
+	}
+}
+
+func TestCompressorPoolFreeOnFinalize(t *testing.T) {
+	pool, err := zstdwrap.NewCompressorPool(&zstdwrap.COptions{FreeOnFinalize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := []byte(strings.Repeat("pooled with a finalizer ", 100))
+	// Take more Compressors than the pool holds, so Get
+	// creates new ones too.
+	var cs []*zstdwrap.Compressor
+	for i := 0; i < 4; i++ {
+		c := pool.Get()
+		compressed, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := zstdwrap.Decompress(nil, compressed); err != nil || string(got) != string(src) {
+			t.Fatalf("round trip failed: %v", err)
+		}
+		cs = append(cs, c)
+	}
+	for _, c := range cs {
+		pool.Put(c)
+	}
+	runtime.GC()
+}
+
+func TestCompressCopy(t *testing.T) {
+	src := make([]byte, 4096)
+	var frames [][]byte
+	var want []string
+	for i := 0; i < 4; i++ {
+		// Reuse the same input buffer for each frame.
+		copy(src, strings.Repeat(fmt.Sprintf("record %d ", i), 500))
+		frame, err := zstdwrap.CompressCopy(src, &zstdwrap.COptions{Checksum: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cap(frame) != len(frame) {
+			t.Errorf("frame %d: cap=%d, len=%d, want no spare capacity", i, cap(frame), len(frame))
+		}
+		frames = append(frames, frame)
+		want = append(want, string(src))
+	}
+	for i := range src {
+		src[i] = 0
+	}
+	for i, frame := range frames {
+		got, err := zstdwrap.Decompress(nil, frame)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if string(got) != want[i] {
+			t.Errorf("frame %d changed when its input buffer was reused", i)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #include <stdlib.h>
+// #include "zstd.h"
+import "C"
+import "unsafe"
+
+// RefPrefix references prefix as the content that precedes the
+// next frame, with ZSTD_CCtx_refPrefix. It is a lightweight
+// alternative to a dictionary, useful for compressing a delta
+// against an earlier buffer.
+//
+// The prefix applies only to the next frame, which must be
+// decompressed with the same prefix. The bytes are copied.
+// An empty prefix removes any pending prefix.
+func (c *Compressor) RefPrefix(prefix []byte) error {
+	p := cPrefix(prefix)
+	res := C.ZSTD_CCtx_refPrefix(c.ctx, p, C.size_t(len(prefix)))
+	if err := isErr("RefPrefix", res); err != nil {
+		freePrefix(&p)
+		return err
+	}
+	freePrefix(&c.prefix)
+	c.prefix = p
+	c.clearDict()
+	return nil
+}
+
+// RefPrefix references prefix, the content that preceded the
+// next frame when it was compressed, with ZSTD_DCtx_refPrefix.
+//
+// The prefix applies only to the next frame.
+// The bytes are copied.
+func (d *Decompressor) RefPrefix(prefix []byte) error {
+	p := cPrefix(prefix)
+	res := C.ZSTD_DCtx_refPrefix(d.ctx, p, C.size_t(len(prefix)))
+	if err := isErr("RefPrefix", res); err != nil {
+		freePrefix(&p)
+		return err
+	}
+	freePrefix(&d.prefix)
+	d.prefix = p
+	d.ddict = nil
+	releaseCBytes(&d.dictRef)
+	return nil
+}
+
+// cPrefix copies prefix into C memory, because zstd
+// keeps a reference to a prefix after refPrefix returns.
+func cPrefix(prefix []byte) unsafe.Pointer {
+	if len(prefix) == 0 {
+		return nil
+	}
+	return C.CBytes(prefix)
+}
+
+func freePrefix(p *unsafe.Pointer) {
+	if *p != nil {
+		C.free(*p)
+		*p = nil
+	}
+}
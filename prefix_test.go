@@ -0,0 +1,136 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestRefPrefix(t *testing.T) {
+	var a, b []byte
+	for i := 0; i < 2000; i++ {
+		line := strings.Repeat(string('a'+byte(i*7%26)), i%13) + " record\n"
+		a = append(a, line...)
+		if i%100 == 0 {
+			line = "changed\n"
+		}
+		b = append(b, line...)
+	}
+
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	plain, err := c.Compress(nil, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RefPrefix(a); err != nil {
+		t.Fatal(err)
+	}
+	delta, err := c.Compress(nil, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta) >= len(plain) {
+		t.Errorf("compressed with prefix %d bytes, without %d bytes", len(delta), len(plain))
+	}
+	again, err := c.Compress(nil, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, plain) {
+		t.Error("prefix used for more than one frame")
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.RefPrefix(a); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decompress(nil, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Error("round trip mismatch")
+	}
+	if _, err := d.Decompress(nil, delta); err == nil {
+		t.Error("second Decompress without prefix succeeded")
+	}
+}
+
+func TestReaderRefPrefix(t *testing.T) {
+	base := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 200))
+	next := append(append([]byte{}, base[:4000]...), "a small edit\n"...)
+	next = append(next, base[4000:]...)
+
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	stream, err := c.Compress(nil, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RefPrefix(base); err != nil {
+		t.Fatal(err)
+	}
+	if stream, err = c.CompressAppend(stream, next); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zstdwrap.NewReader(bytes.NewReader(stream), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got := make([]byte, len(base))
+	if _, err := io.ReadFull(r, got[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RefPrefix(base); !xerrors.Is(err, zstdwrap.ErrStageWrong) {
+		t.Errorf("RefPrefix mid-frame: err=%v, want ErrStageWrong", err)
+	}
+	if _, err := io.ReadFull(r, got[10:]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, base) || !r.FrameComplete() {
+		t.Fatalf("first frame mismatch, FrameComplete=%v", r.FrameComplete())
+	}
+
+	if err := r.RefPrefix(base); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, next) {
+		t.Errorf("delta frame decoded to %d bytes, want %d", len(rest), len(next))
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+import "golang.org/x/xerrors"
+
+// CompressToRatio compresses src into dst at the lowest level
+// that reaches a compression ratio of at least minRatio, that is
+// len(src)/len(frame) >= minRatio, and returns the new dst and
+// the level used.
+//
+// Levels are tried in increasing order from 1, reusing one
+// Compressor and dst, until the ratio is met. If no level up to
+// maxLevel reaches minRatio, the frame compressed at maxLevel is
+// returned. CompressToRatio does not report an error for it;
+// callers compare the ratio themselves if they need to know.
+//
+// Each level tried compresses all of src, so CompressToRatio is
+// for data where compressing several times is acceptable, such
+// as cold storage.
+func CompressToRatio(dst, src []byte, minRatio float64, maxLevel int) ([]byte, int, error) {
+	if err := checkLevel("CompressToRatio", maxLevel); err != nil {
+		return nil, 0, err
+	}
+	if !(minRatio > 0) {
+		return nil, 0, xerrors.Errorf("zstdwrap.CompressToRatio: ratio %v: %w", minRatio, ErrParameterOutOfBound)
+	}
+	c, err := NewCompressor(nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.Delete()
+
+	level := 1
+	if maxLevel < level {
+		level = maxLevel
+	}
+	for ; ; level++ {
+		if err := c.SetParameter(CParamCompressionLevel, level); err != nil {
+			return nil, 0, err
+		}
+		if dst, err = c.Compress(dst, src); err != nil {
+			return nil, 0, err
+		}
+		if level >= maxLevel || float64(len(src)) >= minRatio*float64(len(dst)) {
+			return dst, level, nil
+		}
+		if err := c.Reset(ResetSessionOnly); err != nil {
+			return nil, 0, err
+		}
+	}
+}
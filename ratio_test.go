@@ -0,0 +1,73 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestCompressToRatio(t *testing.T) {
+	words := strings.Fields("the quick brown fox jumps over a lazy dog while zstd finds long matches in repetitive english text")
+	rnd := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for buf.Len() < 256<<10 {
+		buf.WriteString(words[rnd.Intn(len(words))])
+		buf.WriteByte(' ')
+	}
+	src := buf.Bytes()
+	ratio := func(frame []byte) float64 { return float64(len(src)) / float64(len(frame)) }
+
+	const maxLevel = 12
+	for _, minRatio := range []float64{1, 5.2, 5.5, 5.9, 1000} {
+		frame, level, err := zstdwrap.CompressToRatio(nil, src, minRatio, maxLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ratio(frame)
+		t.Logf("minRatio %v: level %d, ratio %.3f", minRatio, level, got)
+		if level < maxLevel && got < minRatio {
+			t.Errorf("minRatio %v: level %d ratio %.3f below threshold", minRatio, level, got)
+		}
+		if level > 1 {
+			prev, err := zstdwrap.Compress(nil, src, level-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ratio(prev) >= minRatio {
+				t.Errorf("minRatio %v: level %d chosen, but level %d reaches %.3f", minRatio, level, level-1, ratio(prev))
+			}
+		}
+		out, err := zstdwrap.Decompress(nil, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, src) {
+			t.Errorf("minRatio %v: round trip mismatch", minRatio)
+		}
+	}
+
+	if _, _, err := zstdwrap.CompressToRatio(nil, src, 0, maxLevel); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+		t.Errorf("zero ratio: err=%v, want ErrParameterOutOfBound", err)
+	}
+	if _, _, err := zstdwrap.CompressToRatio(nil, src, 2, zstdwrap.MaxCompressionLevel()+1); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+		t.Errorf("bad level: err=%v, want ErrParameterOutOfBound", err)
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #include "zstd.h"
+//
+// // zstdwrap_decompressStream calls ZSTD_decompressStream with buffers
+// // built on the C side, so no Go pointers are stored in C memory.
+// static size_t zstdwrap_decompressStream(ZSTD_DCtx* dctx,
+// 	void* dst, size_t dstSize, size_t* dstPos,
+// 	const void* src, size_t srcSize, size_t* srcPos) {
+// 	ZSTD_outBuffer out = { dst, dstSize, *dstPos };
+// 	ZSTD_inBuffer in = { src, srcSize, *srcPos };
+// 	size_t res = ZSTD_decompressStream(dctx, &out, &in);
+// 	*dstPos = out.pos;
+// 	*srcPos = in.pos;
+// 	return res;
+// }
+import "C"
+import (
+	"errors"
+	"io"
+	"unsafe"
+
+	"golang.org/x/xerrors"
+)
+
+var errReaderClosed = errors.New("zstdwrap.Reader: read after Close")
+
+// ErrOutputLimitExceeded is reported by a Reader when the content
+// decoded exceeds the limit set with SetMaxOutput.
+var ErrOutputLimitExceeded = errors.New("zstdwrap: output limit exceeded")
+
+// Reader is an io.ReadCloser that decompresses a stream of
+// one or more zstd frames read from an underlying io.Reader.
+//
+// Unlike Decompress, Reader does not need to know the content
+// size of a frame in advance.
+//
+// Reader is built on ZSTD_decompressStream.
+type Reader struct {
+	r    io.Reader
+	d    *Decompressor
+	rerr error // error from r, reported once in is empty
+	err  error // sticky
+
+	in           []byte // ZSTD_DStreamInSize buffer
+	inPos, inEnd int
+
+	out            []byte // ZSTD_DStreamOutSize buffer
+	outPos, outEnd int
+
+	flushing  bool // out was filled, zstd may hold more output
+	frameDone bool // no partially decoded frame
+
+	hdr         []byte // start of the current frame, until its header is parsed
+	hdrDone     bool
+	contentSize int64 // -1 if unknown
+
+	maxOutput int64 // 0 for no limit
+	total     int64 // content decoded
+}
+
+// DStreamInSize reports the size of the buffer a Reader uses to
+// hold compressed input, with ZSTD_DStreamInSize.
+func DStreamInSize() int { return int(C.ZSTD_DStreamInSize()) }
+
+// DStreamOutSize reports the recommended size of the buffers
+// passed to Reader.Read, with ZSTD_DStreamOutSize. It is enough
+// to hold one decompressed block.
+func DStreamOutSize() int { return int(C.ZSTD_DStreamOutSize()) }
+
+// NewReader creates a Reader that decompresses from r.
+//
+// The windowLogMax is interpreted as by NewDecompressor.
+// The Reader owns its Decompressor, which is released by Close.
+func NewReader(r io.Reader, windowLogMax int) (*Reader, error) {
+	d, err := NewDecompressor(windowLogMax)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		r:           r,
+		d:           d,
+		in:          make([]byte, DStreamInSize()),
+		out:         make([]byte, DStreamOutSize()),
+		frameDone:   true,
+		contentSize: -1,
+	}, nil
+}
+
+// Read decompresses into p.
+//
+// Read returns io.EOF after the underlying reader is exhausted
+// at a frame boundary. If the underlying reader ends in the
+// middle of a frame, Read reports io.ErrUnexpectedEOF.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.d == nil {
+		return 0, errReaderClosed
+	}
+	for {
+		if r.outPos < r.outEnd {
+			n = copy(p, r.out[r.outPos:r.outEnd])
+			r.outPos += n
+			return n, nil
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if r.inPos == r.inEnd && !r.flushing {
+			r.inPos, r.inEnd = 0, 0
+			if r.rerr == nil {
+				r.inEnd, r.rerr = r.r.Read(r.in)
+			}
+			if r.inEnd == 0 {
+				if r.rerr != nil {
+					r.err = r.rerr
+					if r.err == io.EOF && !r.frameDone {
+						r.err = io.ErrUnexpectedEOF
+					}
+				}
+				continue
+			}
+		}
+		r.decompress()
+	}
+}
+
+// FrameComplete reports whether all the content of the frames
+// read so far has been returned by Read. When it is true after
+// a Read, that Read returned the end of a frame.
+//
+// A single Read never returns content from more than one frame,
+// so FrameComplete can find message boundaries in a stream of
+// one frame per message.
+func (r *Reader) FrameComplete() bool {
+	return r.frameDone && r.outPos == r.outEnd
+}
+
+// SetMaxOutput limits the total content the Reader decodes, over
+// all frames, to n bytes. Once more would be decoded, Read returns
+// the first n bytes and then reports ErrOutputLimitExceeded.
+// A limit of zero, the default, means no limit.
+//
+// The window log bounds the memory used to decode a frame, not
+// its size: a small, highly compressible stream can decode to
+// any amount of content. SetMaxOutput protects a consumer of
+// untrusted input from such compression bombs.
+func (r *Reader) SetMaxOutput(n int64) {
+	r.maxOutput = n
+}
+
+// RefPrefix references prefix, the content that preceded the next
+// frame when it was compressed, as Decompressor.RefPrefix does.
+// It decodes frames compressed as a delta with
+// Compressor.RefPrefix from a stream.
+//
+// The prefix applies only to the next frame. It must be called
+// between frames, when FrameComplete reports true, otherwise it
+// reports ErrStageWrong.
+func (r *Reader) RefPrefix(prefix []byte) error {
+	if r.d == nil {
+		return errReaderClosed
+	}
+	if !r.FrameComplete() {
+		return xerrors.Errorf("zstdwrap.Reader.RefPrefix: frame in progress: %w", ErrStageWrong)
+	}
+	return r.d.RefPrefix(prefix)
+}
+
+// ContentSize reports the content size recorded in the header
+// of the frame being read, for preallocating a destination.
+// It is known once a Read has consumed the frame header.
+//
+// ContentSize reports false before the first Read, if the frame
+// header does not record the size, and for skippable frames.
+func (r *Reader) ContentSize() (int64, bool) {
+	if r.contentSize < 0 {
+		return 0, false
+	}
+	return r.contentSize, true
+}
+
+// parseHeader collects the frame header from the input about
+// to be decompressed, and records the content size.
+func (r *Reader) parseHeader() {
+	if r.frameDone {
+		// Starting a new frame.
+		r.hdr = r.hdr[:0]
+		r.hdrDone = false
+		r.contentSize = -1
+	}
+	if r.hdrDone || r.inPos == r.inEnd {
+		return
+	}
+	// The header is at most ZSTD_FRAMEHEADERSIZE_MAX bytes. If
+	// fewer are buffered, all of them are consumed by this call,
+	// so the next call continues where this one ends.
+	n := C.ZSTD_FRAMEHEADERSIZE_MAX - len(r.hdr)
+	if n > r.inEnd-r.inPos {
+		n = r.inEnd - r.inPos
+	}
+	r.hdr = append(r.hdr, r.in[r.inPos:r.inPos+n]...)
+	var zfh C.ZSTD_frameHeader
+	res := C.ZSTD_getFrameHeader_advanced(&zfh, unsafe.Pointer(&r.hdr[0]), C.size_t(len(r.hdr)), C.ZSTD_format_e(r.d.format))
+	if res != 0 && C.ZSTD_isError(res) == 0 {
+		return // need more input
+	}
+	r.hdrDone = true
+	if res == 0 && zfh.frameType == C.ZSTD_frame && zfh.frameContentSize != C.ZSTD_CONTENTSIZE_UNKNOWN {
+		r.contentSize = int64(zfh.frameContentSize)
+	}
+}
+
+func (r *Reader) decompress() {
+	r.parseHeader()
+	var dstPos C.size_t
+	srcPos := C.size_t(r.inPos)
+	var srcv unsafe.Pointer
+	if r.inEnd > 0 {
+		srcv = unsafe.Pointer(&r.in[0])
+	}
+	res := C.zstdwrap_decompressStream(r.d.ctx,
+		unsafe.Pointer(&r.out[0]), C.size_t(len(r.out)), &dstPos,
+		srcv, C.size_t(r.inEnd), &srcPos)
+	r.inPos = int(srcPos)
+	r.outPos, r.outEnd = 0, int(dstPos)
+	if err := isErr("Reader", res); err != nil {
+		r.err = err
+		return
+	}
+	r.total += int64(dstPos)
+	if r.maxOutput > 0 && r.total > r.maxOutput {
+		r.outEnd -= int(r.total - r.maxOutput)
+		r.err = xerrors.Errorf("zstdwrap.Reader: limit %d: %w", r.maxOutput, ErrOutputLimitExceeded)
+		return
+	}
+	r.frameDone = res == 0
+	// A full out may hold back more output, unless the frame
+	// is complete: another call would start the next frame.
+	r.flushing = !r.frameDone && r.outEnd == len(r.out)
+}
+
+// Close releases the Reader's Decompressor.
+// It does not close the underlying io.Reader.
+// Calling Close more than once is safe.
+func (r *Reader) Close() error {
+	if r.d == nil {
+		return nil
+	}
+	err := r.d.Delete()
+	r.d = nil
+	return err
+}
+
+// decompressStream decompresses src when its content size is
+// unknown, growing dst up to the Decompressor's window limit.
+func (d *Decompressor) decompressStream(dst, src []byte) ([]byte, error) {
+	if err := isErr("Decompress", C.ZSTD_DCtx_reset(d.ctx, C.ZSTD_reset_session_only)); err != nil {
+		return nil, err
+	}
+	limit := d.maxContentSize()
+	srcv := unsafe.Pointer(&src[0])
+	var dstPos, srcPos C.size_t
+	for {
+		if int(dstPos) == len(dst) {
+			if int64(len(dst)) > limit {
+				return nil, xerrors.Errorf("zstdwrap.Decompress: %w", &FrameTooBigError{Limit: limit})
+			}
+			// Grow to one past the limit, so a frame
+			// that exceeds it is detected.
+			n := 2 * len(dst)
+			if min := DStreamOutSize(); n < min {
+				n = min
+			}
+			if int64(n) > limit+1 {
+				n = int(limit + 1)
+			}
+			dst = append(dst, make([]byte, n-len(dst))...)
+		}
+		res := C.zstdwrap_decompressStream(d.ctx,
+			unsafe.Pointer(&dst[0]), C.size_t(len(dst)), &dstPos,
+			srcv, C.size_t(len(src)), &srcPos)
+		if err := isErr("Decompress", res); err != nil {
+			return nil, err
+		}
+		if int(srcPos) == len(src) {
+			if res == 0 {
+				break // last frame complete and flushed
+			}
+			if int(dstPos) < len(dst) {
+				return nil, xerrors.Errorf("zstdwrap.Decompress: %w", ErrSrcSizeWrong)
+			}
+		}
+	}
+	if int64(dstPos) > limit {
+		return nil, xerrors.Errorf("zstdwrap.Decompress: %w", &FrameTooBigError{Limit: limit})
+	}
+	return dst[:int(dstPos)], nil
+}
+
+// decompressStreamInto decompresses src into dst without
+// growing it, for DecompressInto.
+func (d *Decompressor) decompressStreamInto(dst, src []byte) (n int, err error) {
+	if err := isErr("DecompressInto", C.ZSTD_DCtx_reset(d.ctx, C.ZSTD_reset_session_only)); err != nil {
+		return 0, err
+	}
+	var dstv unsafe.Pointer
+	if len(dst) > 0 {
+		dstv = unsafe.Pointer(&dst[0])
+	}
+	srcv := unsafe.Pointer(&src[0])
+	dstPos, srcPos := &d.dstPos, &d.srcPos
+	*dstPos, *srcPos = 0, 0
+	for {
+		lastDst, lastSrc := *dstPos, *srcPos
+		res := C.zstdwrap_decompressStream(d.ctx,
+			dstv, C.size_t(len(dst)), dstPos,
+			srcv, C.size_t(len(src)), srcPos)
+		if err := isErr("DecompressInto", res); err != nil {
+			return 0, err
+		}
+		if int(*srcPos) == len(src) {
+			if res == 0 {
+				return int(*dstPos), nil
+			}
+			if int(*dstPos) < len(dst) {
+				return 0, xerrors.Errorf("zstdwrap.DecompressInto: %w", ErrSrcSizeWrong)
+			}
+		}
+		if *dstPos == lastDst && *srcPos == lastSrc {
+			return 0, xerrors.Errorf("zstdwrap.DecompressInto: %w", ErrDstSizeTooSmall)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+// compressStream compresses src with a Writer, so the
+// frame does not record its content size.
+func compressStream(t *testing.T, src string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	w, err := zstdwrap.NewWriter(buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	src1 := strings.Repeat("Hello, World!\n", 20000)
+	src2 := strings.Repeat("Goodbye.\n", 300)
+	frame1 := compressStream(t, src1)
+	frame2 := compressStream(t, src2)
+
+	if _, err := zstdwrap.FrameContentSize(frame1); err != zstdwrap.ErrContentSizeUnknown {
+		t.Fatalf("streamed frame FrameContentSize err=%v, want ErrContentSizeUnknown", err)
+	}
+
+	t.Run("ReadAll", func(t *testing.T) {
+		r, err := zstdwrap.NewReader(bytes.NewReader(frame1), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src1 {
+			t.Errorf("round trip mismatch: len=%d, want %d", len(got), len(src1))
+		}
+	})
+
+	t.Run("OneByte", func(t *testing.T) {
+		r, err := zstdwrap.NewReader(iotest.OneByteReader(bytes.NewReader(frame2)), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(iotest.OneByteReader(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src2 {
+			t.Errorf("round trip mismatch: %q", got)
+		}
+	})
+
+	t.Run("Concatenated", func(t *testing.T) {
+		src := append(append([]byte{}, frame1...), frame2...)
+		r, err := zstdwrap.NewReader(bytes.NewReader(src), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src1+src2 {
+			t.Errorf("round trip mismatch: len=%d, want %d", len(got), len(src1)+len(src2))
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		r, err := zstdwrap.NewReader(bytes.NewReader(frame1[:len(frame1)-4]), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadAll err=%v, want io.ErrUnexpectedEOF", err)
+		}
+	})
+
+	t.Run("FrameComplete", func(t *testing.T) {
+		msgs := []string{"first message", src1, "third message"}
+		var stream []byte
+		for _, msg := range msgs {
+			stream = append(stream, compressStream(t, msg)...)
+		}
+		r, err := zstdwrap.NewReader(bytes.NewReader(stream), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		var got []string
+		var msg []byte
+		p := make([]byte, 1000)
+		for {
+			n, err := r.Read(p)
+			msg = append(msg, p[:n]...)
+			if r.FrameComplete() && len(msg) > 0 {
+				got = append(got, string(msg))
+				msg = nil
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(got) != len(msgs) {
+			t.Fatalf("found %d messages, want %d", len(got), len(msgs))
+		}
+		for i := range msgs {
+			if got[i] != msgs[i] {
+				t.Errorf("message %d has %d bytes, want %d", i, len(got[i]), len(msgs[i]))
+			}
+		}
+	})
+
+	t.Run("ContentSize", func(t *testing.T) {
+		sized, err := zstdwrap.Compress(nil, []byte(src1), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := zstdwrap.FrameContentSize(sized)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream := append(append([]byte{}, sized...), compressStream(t, "unsized")...)
+
+		for name, in := range map[string]io.Reader{
+			"buffered":     bytes.NewReader(stream),
+			"byte-by-byte": iotest.OneByteReader(bytes.NewReader(stream)),
+		} {
+			r, err := zstdwrap.NewReader(in, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, ok := r.ContentSize(); ok {
+				t.Errorf("%s: ContentSize before Read=%d, true", name, n)
+			}
+			p := make([]byte, 10)
+			if _, err := io.ReadFull(r, p); err != nil {
+				t.Fatal(err)
+			}
+			if n, ok := r.ContentSize(); !ok || n != want {
+				t.Errorf("%s: ContentSize=%d, %v, want %d", name, n, ok, want)
+			}
+			rest := make([]byte, len(src1)-len(p))
+			if _, err := io.ReadFull(r, rest); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(r, p[:1]); err != nil {
+				t.Fatal(err)
+			}
+			if n, ok := r.ContentSize(); ok {
+				t.Errorf("%s: unsized frame ContentSize=%d, true", name, n)
+			}
+			r.Close()
+		}
+	})
+
+	t.Run("MaxOutput", func(t *testing.T) {
+		// 64MB of zeros compress to a few kilobytes.
+		const size, limit = 64 << 20, 1 << 20
+		bomb := compressStream(t, strings.Repeat("\x00", size))
+
+		r, err := zstdwrap.NewReader(bytes.NewReader(bomb), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		r.SetMaxOutput(limit)
+		n, err := io.Copy(ioutil.Discard, r)
+		if !xerrors.Is(err, zstdwrap.ErrOutputLimitExceeded) {
+			t.Errorf("err=%v, want ErrOutputLimitExceeded", err)
+		}
+		if n != limit {
+			t.Errorf("read %d bytes, want the limit %d", n, limit)
+		}
+		if _, err := r.Read(make([]byte, 1)); !xerrors.Is(err, zstdwrap.ErrOutputLimitExceeded) {
+			t.Errorf("second Read err=%v, want ErrOutputLimitExceeded", err)
+		}
+
+		// Content of exactly the limit is allowed.
+		r2, err := zstdwrap.NewReader(bytes.NewReader(bomb), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r2.Close()
+		r2.SetMaxOutput(size)
+		if n, err := io.Copy(ioutil.Discard, r2); err != nil || n != size {
+			t.Errorf("limit of the content size: n=%d, err=%v", n, err)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #include "zstd.h"
+import "C"
+import (
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// The bundled zstd predates ZSTD_writeSkippableFrame,
+// ZSTD_readSkippableFrame, and ZSTD_isSkippableFrame,
+// so skippable frames are built here following RFC 8478:
+// a 4-byte little-endian magic number in the range
+// ZSTD_MAGIC_SKIPPABLE_START to ZSTD_MAGIC_SKIPPABLE_START+15,
+// a 4-byte little-endian data size, then the data.
+
+// MaxSkippableMagicVariant is the largest magicVariant
+// a skippable frame can carry.
+const MaxSkippableMagicVariant = 15
+
+// WriteSkippableFrame writes a skippable frame holding data
+// into dst, and returns the new dst.
+//
+// Decoders, including Decompress and Reader, ignore skippable
+// frames, which makes them suitable for embedding metadata.
+// The magicVariant, from 0 to 15, is stored in the low bits
+// of the frame's magic number.
+func WriteSkippableFrame(dst []byte, magicVariant uint32, data []byte) ([]byte, error) {
+	if magicVariant > MaxSkippableMagicVariant {
+		return nil, xerrors.Errorf("zstdwrap.WriteSkippableFrame: magic variant %d: %w", magicVariant, ErrParameterOutOfBound)
+	}
+	if uint64(len(data)) > 0xffffffff {
+		return nil, xerrors.Errorf("zstdwrap.WriteSkippableFrame: %d bytes of data: %w", len(data), ErrSrcSizeWrong)
+	}
+	need := C.ZSTD_SKIPPABLEHEADERSIZE + len(data)
+	if cap(dst) < need {
+		dst = append(dst, make([]byte, need-len(dst))...)
+	} else {
+		dst = dst[:need]
+	}
+	binary.LittleEndian.PutUint32(dst[0:], C.ZSTD_MAGIC_SKIPPABLE_START+magicVariant)
+	binary.LittleEndian.PutUint32(dst[4:], uint32(len(data)))
+	copy(dst[C.ZSTD_SKIPPABLEHEADERSIZE:], data)
+	return dst, nil
+}
+
+// ReadSkippableFrame reads the skippable frame at the start of src.
+//
+// The returned data is a sub-slice of src, it is not copied.
+// If src does not start with a skippable frame, ReadSkippableFrame
+// reports ErrPrefixUnknown. If src is shorter than the frame,
+// it reports ErrSrcSizeWrong.
+func ReadSkippableFrame(src []byte) (magicVariant uint32, data []byte, err error) {
+	if len(src) < C.ZSTD_SKIPPABLEHEADERSIZE {
+		return 0, nil, xerrors.Errorf("zstdwrap.ReadSkippableFrame: %w", ErrSrcSizeWrong)
+	}
+	if !IsSkippableFrame(src) {
+		return 0, nil, xerrors.Errorf("zstdwrap.ReadSkippableFrame: %w", ErrPrefixUnknown)
+	}
+	magic := binary.LittleEndian.Uint32(src)
+	size := uint64(binary.LittleEndian.Uint32(src[4:]))
+	if size > uint64(len(src)-C.ZSTD_SKIPPABLEHEADERSIZE) {
+		return 0, nil, xerrors.Errorf("zstdwrap.ReadSkippableFrame: %w", ErrSrcSizeWrong)
+	}
+	data = src[C.ZSTD_SKIPPABLEHEADERSIZE : C.ZSTD_SKIPPABLEHEADERSIZE+int(size)]
+	return magic - C.ZSTD_MAGIC_SKIPPABLE_START, data, nil
+}
+
+// IsSkippableFrame reports whether src starts with
+// a skippable frame magic number.
+func IsSkippableFrame(src []byte) bool {
+	if len(src) < 4 {
+		return false
+	}
+	magic := binary.LittleEndian.Uint32(src)
+	return magic&C.ZSTD_MAGIC_SKIPPABLE_MASK == C.ZSTD_MAGIC_SKIPPABLE_START
+}
+
+// FrameType classifies a frame by its magic number.
+type FrameType int
+
+const (
+	FrameTypeUnknown   FrameType = iota
+	FrameTypeZstd                // a zstd frame, for Decompress
+	FrameTypeSkippable           // a skippable frame, for ReadSkippableFrame
+)
+
+// PeekFrameType reports the type of the frame at the start of
+// src from its magic number alone, without parsing the header.
+// It is a fast way to route frames in a mixed stream.
+//
+// If src is shorter than a magic number, PeekFrameType
+// reports a *NeedMoreError.
+func PeekFrameType(src []byte) (FrameType, error) {
+	if len(src) < 4 {
+		return FrameTypeUnknown, &NeedMoreError{Need: 4}
+	}
+	magic := binary.LittleEndian.Uint32(src)
+	switch {
+	case magic == C.ZSTD_MAGICNUMBER:
+		return FrameTypeZstd, nil
+	case magic&C.ZSTD_MAGIC_SKIPPABLE_MASK == C.ZSTD_MAGIC_SKIPPABLE_START:
+		return FrameTypeSkippable, nil
+	}
+	return FrameTypeUnknown, nil
+}
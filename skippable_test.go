@@ -0,0 +1,134 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestSkippableFrame(t *testing.T) {
+	manifest := []byte(`{"name":"data.txt","size":9}`)
+	frame, err := zstdwrap.WriteSkippableFrame(nil, 7, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !zstdwrap.IsSkippableFrame(frame) {
+		t.Error("IsSkippableFrame=false, want true")
+	}
+
+	variant, data, err := zstdwrap.ReadSkippableFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variant != 7 || !bytes.Equal(data, manifest) {
+		t.Errorf("ReadSkippableFrame=%d, %q, want 7, %q", variant, data, manifest)
+	}
+
+	t.Run("Reader", func(t *testing.T) {
+		buf := append(frame, compressStream(t, "some data")...)
+		sizes, _, err := zstdwrap.FrameSizes(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sizes) != 2 || sizes[0] != len(frame) {
+			t.Errorf("FrameSizes=%v, want two frames, first %d bytes", sizes, len(frame))
+		}
+		r, err := zstdwrap.NewReader(bytes.NewReader(buf), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "some data" {
+			t.Errorf("Reader read %q, want %q", got, "some data")
+		}
+	})
+
+	t.Run("NotSkippable", func(t *testing.T) {
+		src := compressStream(t, "some data")
+		if zstdwrap.IsSkippableFrame(src) {
+			t.Error("IsSkippableFrame=true, want false")
+		}
+		if _, _, err := zstdwrap.ReadSkippableFrame(src); !xerrors.Is(err, zstdwrap.ErrPrefixUnknown) {
+			t.Errorf("ReadSkippableFrame err=%v, want ErrPrefixUnknown", err)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		if _, _, err := zstdwrap.ReadSkippableFrame(frame[:len(frame)-1]); !xerrors.Is(err, zstdwrap.ErrSrcSizeWrong) {
+			t.Errorf("ReadSkippableFrame err=%v, want ErrSrcSizeWrong", err)
+		}
+	})
+
+	t.Run("BadVariant", func(t *testing.T) {
+		if _, err := zstdwrap.WriteSkippableFrame(nil, 16, manifest); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+			t.Errorf("WriteSkippableFrame err=%v, want ErrParameterOutOfBound", err)
+		}
+	})
+}
+
+func TestPeekFrameType(t *testing.T) {
+	frame, err := zstdwrap.Compress(nil, []byte("hello"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		src  []byte
+		want zstdwrap.FrameType
+	}{
+		{"zstd", frame, zstdwrap.FrameTypeZstd},
+		{"zstd magic only", frame[:4], zstdwrap.FrameTypeZstd},
+		{"text", []byte("hello, world"), zstdwrap.FrameTypeUnknown},
+		{"legacy v0.7", []byte{0x27, 0xb5, 0x2f, 0xfd}, zstdwrap.FrameTypeUnknown},
+		{"dictionary", []byte{0x37, 0xa4, 0x30, 0xec}, zstdwrap.FrameTypeUnknown},
+	}
+	for v := uint32(0); v <= zstdwrap.MaxSkippableMagicVariant; v++ {
+		skip, err := zstdwrap.WriteSkippableFrame(nil, v, []byte("meta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests = append(tests, struct {
+			name string
+			src  []byte
+			want zstdwrap.FrameType
+		}{fmt.Sprintf("skippable %d", v), skip, zstdwrap.FrameTypeSkippable})
+	}
+	for _, test := range tests {
+		got, err := zstdwrap.PeekFrameType(test.src)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: PeekFrameType=%d, want %d", test.name, got, test.want)
+		}
+	}
+
+	for n := 0; n < 4; n++ {
+		_, err := zstdwrap.PeekFrameType(frame[:n])
+		var need *zstdwrap.NeedMoreError
+		if !xerrors.As(err, &need) || need.Need != 4 {
+			t.Errorf("%d bytes: err=%v, want NeedMoreError{4}", n, err)
+		}
+	}
+}
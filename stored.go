@@ -0,0 +1,170 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #include "zstd.h"
+import "C"
+import (
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// maxStored is the largest input SkipCompressionBelow applies to,
+// the content of a single block.
+const maxStored = C.ZSTD_BLOCKSIZE_MAX
+
+// storedSize reports the size of the frame storedFrame
+// writes for n bytes of content.
+func storedSize(format Format, contentSize bool, n int) int {
+	size := 1 + n // Frame_Header_Descriptor
+	if format == FormatZstd1 {
+		size += 4
+	}
+	if !contentSize || n > maxStored {
+		size++ // Window_Descriptor
+	}
+	switch {
+	case !contentSize:
+	case n < 256:
+		size++
+	case n < 65536+256:
+		size += 2
+	case uint64(n) < 1<<32:
+		size += 4
+	default:
+		size += 8
+	}
+	blocks := (n + maxStored - 1) / maxStored
+	if blocks == 0 {
+		blocks = 1
+	}
+	return size + 3*blocks
+}
+
+// storedFrame writes src into dst as a frame of raw blocks,
+// following RFC 8478, without calling into zstd.
+//
+// The frame records the content size, as ZSTD_compress2 does,
+// unless contentSize is false. It has no checksum or dictionary
+// ID. Content of one block with a recorded size is
+// single-segment, so its window is the content size. Otherwise
+// the window is one block, rather than the whole content, so a
+// decoder does not need a window as large as the input.
+// Raw blocks never refer back, so that is enough.
+func storedFrame(loc string, format Format, contentSize bool, dst, src []byte) (n int, err error) {
+	const (
+		singleSegment = 1 << 5 // Frame_Header_Descriptor
+		lastBlock     = 1      // Block_Header, Block_Type 0 is Raw_Block
+
+		// Window_Descriptor for a window of maxStored bytes:
+		// an exponent of log2(maxStored)-10 and no mantissa.
+		blockWindow = (C.ZSTD_BLOCKSIZELOG_MAX - 10) << 3
+	)
+	if len(dst) < storedSize(format, contentSize, len(src)) {
+		return 0, xerrors.Errorf("zstdwrap.%s: %w", loc, ErrDstSizeTooSmall)
+	}
+	if format == FormatZstd1 {
+		binary.LittleEndian.PutUint32(dst[n:], C.ZSTD_MAGICNUMBER)
+		n += 4
+	}
+	fhd := n
+	n++
+	if contentSize && len(src) <= maxStored {
+		dst[fhd] = singleSegment
+	} else {
+		dst[fhd] = 0
+		dst[n] = blockWindow
+		n++
+	}
+	switch size := len(src); {
+	case !contentSize:
+		// A Frame_Content_Size_Flag of 0 without
+		// Single_Segment means no content size.
+	case size < 256:
+		// Only reached single-segment, where a
+		// Frame_Content_Size_Flag of 0 means one byte.
+		dst[n] = byte(size)
+		n++
+	case size < 65536+256:
+		dst[fhd] |= 1 << 6
+		binary.LittleEndian.PutUint16(dst[n:], uint16(size-256))
+		n += 2
+	case uint64(size) < 1<<32:
+		dst[fhd] |= 2 << 6
+		binary.LittleEndian.PutUint32(dst[n:], uint32(size))
+		n += 4
+	default:
+		dst[fhd] |= 3 << 6
+		binary.LittleEndian.PutUint64(dst[n:], uint64(size))
+		n += 8
+	}
+	for {
+		block := src
+		if len(block) > maxStored {
+			block = block[:maxStored]
+		}
+		src = src[len(block):]
+		bh := uint32(len(block)) << 3
+		if len(src) == 0 {
+			bh |= lastBlock
+		}
+		dst[n], dst[n+1], dst[n+2] = byte(bh), byte(bh>>8), byte(bh>>16)
+		n += 3
+		n += copy(dst[n:], block)
+		if len(src) == 0 {
+			return n, nil
+		}
+	}
+}
+
+// FrameOverhead reports the fewest bytes a frame compressed with
+// opts adds to its content: the magic number, the frame header,
+// one block header, and the checksum if enabled.
+//
+// It is exact for an empty input. Larger inputs need more bytes
+// to record the content size, up to 7 more, and a block header
+// per 128kb block. Comparing it with the expected saving helps
+// choose COptions.SkipCompressionBelow.
+func FrameOverhead(opts *COptions) int {
+	if opts == nil {
+		opts = &COptions{}
+	}
+	const blockHeader = 3
+	n := 1 + 1 + blockHeader // descriptor and one-byte content size
+	if opts.Format == FormatZstd1 {
+		n += 4
+	}
+	var dictID uint32
+	if len(opts.Dictionary) > 0 {
+		dictID = GetDictIDFromDict(opts.Dictionary)
+	} else if opts.CDict != nil {
+		dictID = opts.CDict.id
+	}
+	switch {
+	case dictID == 0:
+	case dictID < 1<<8:
+		n++
+	case dictID < 1<<16:
+		n += 2
+	default:
+		n += 4
+	}
+	if opts.Checksum {
+		n += 4
+	}
+	return n
+}
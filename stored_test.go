@@ -0,0 +1,329 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestSkipCompressionBelow(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{SkipCompressionBelow: 128 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 16, 255, 256, 300, 65791, 65792, 100000, 128<<10 - 1} {
+		src := make([]byte, n)
+		rnd.Read(src)
+		frame, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frame) > n+12 {
+			t.Errorf("%d bytes: frame is %d bytes", n, len(frame))
+		}
+		if sz, err := zstdwrap.FrameContentSize(frame); err != nil || sz != int64(n) {
+			t.Errorf("%d bytes: FrameContentSize=%d, %v", n, sz, err)
+		}
+		got, err := zstdwrap.Decompress(nil, frame)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("%d bytes: round trip mismatch", n)
+		}
+		r, err := zstdwrap.NewReader(bytes.NewReader(frame), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, src) {
+			t.Errorf("%d bytes: Reader round trip: %v", n, err)
+		}
+	}
+
+	// Compressible input at the threshold goes through zstd.
+	long := bytes.Repeat([]byte("a"), 128<<10)
+	frame, err := c.Compress(nil, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frame) > 1000 {
+		t.Errorf("input at the threshold was stored: %d bytes", len(frame))
+	}
+
+	// Checksums are left to zstd.
+	if err := c.SetParameter(zstdwrap.CParamChecksumFlag, 1); err != nil {
+		t.Fatal(err)
+	}
+	frame, err = c.Compress(nil, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr, err := zstdwrap.ReadFrameHeader(frame); err != nil || !hdr.Checksum {
+		t.Errorf("with checksum: Checksum=%v, %v", hdr.Checksum, err)
+	}
+
+	if _, err := zstdwrap.NewCompressor(&zstdwrap.COptions{SkipCompressionBelow: 128<<10 + 1}); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+		t.Errorf("SkipCompressionBelow too large: err=%v, want ErrParameterOutOfBound", err)
+	}
+}
+
+func TestSkipCompressionBelowMagicless(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+		SkipCompressionBelow: 64,
+		Format:               zstdwrap.FormatZstd1Magicless,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	src := []byte("tiny message")
+	frame, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.SetFormat(zstdwrap.FormatZstd1Magicless); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decompress(nil, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Error("round trip mismatch")
+	}
+	buf := make([]byte, 4)
+	if _, err := c.CompressInto(buf, src); !xerrors.Is(err, zstdwrap.ErrDstSizeTooSmall) {
+		t.Errorf("CompressInto small dst: err=%v, want ErrDstSizeTooSmall", err)
+	}
+}
+
+func BenchmarkCompressSmall(b *testing.B) {
+	src := []byte("sixteen bytes!!!")
+	for _, bm := range []struct {
+		name      string
+		skipBelow int
+	}{
+		{"zstd", 0},
+		{"stored", 64},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{SkipCompressionBelow: bm.skipBelow})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Delete()
+			dst := make([]byte, zstdwrap.CompressBound(len(src)))
+			b.SetBytes(int64(len(src)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.CompressInto(dst, src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameOverhead(t *testing.T) {
+	dict, err := zstdwrap.TrainDictionary(4096, samples(2000, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cd, err := zstdwrap.NewCDict(dict, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cd.Delete()
+
+	for name, opts := range map[string]*zstdwrap.COptions{
+		"nil":                 nil,
+		"default":             {},
+		"checksum":            {Checksum: true},
+		"magicless":           {Format: zstdwrap.FormatZstd1Magicless},
+		"magicless-checksum":  {Format: zstdwrap.FormatZstd1Magicless, Checksum: true},
+		"dictionary":          {Dictionary: dict},
+		"cdict-checksum":      {CDict: cd, Checksum: true},
+		"skip-compression":    {SkipCompressionBelow: 100},
+		"level19-contentsize": {CompressionLevel: 19, ContentSizeFlag: true},
+	} {
+		c, err := zstdwrap.NewCompressor(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		empty, err := c.Compress(nil, nil)
+		c.Delete()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := zstdwrap.FrameOverhead(opts); got != len(empty) {
+			t.Errorf("%s: FrameOverhead=%d, empty frame is %d bytes", name, got, len(empty))
+		}
+	}
+}
+
+func TestAllowStored(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 1<<20)
+	rnd.Read(random)
+	text := bytes.Repeat([]byte("compressible text\n"), 10000)
+
+	for _, format := range []zstdwrap.Format{zstdwrap.FormatZstd1, zstdwrap.FormatZstd1Magicless} {
+		plain, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CompressionLevel: 1, Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer plain.Delete()
+		stored, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CompressionLevel: 1, Format: format, AllowStored: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stored.Delete()
+		d, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{WindowLogMax: 21})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Delete()
+		if err := d.SetFormat(format); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, src := range [][]byte{random, random[:1000], text} {
+			want, err := plain.Compress(nil, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame, err := stored.Compress(nil, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frame) > len(want) {
+				t.Errorf("format %d, %d bytes: AllowStored frame %d bytes, larger than %d", format, len(src), len(frame), len(want))
+			}
+			got, err := d.Decompress(nil, frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, src) {
+				t.Errorf("format %d, %d bytes: round trip mismatch", format, len(src))
+			}
+		}
+
+		// 1mb of random data is stored as 8 raw blocks with a
+		// window descriptor and a 4-byte content size.
+		frame, err := stored.Compress(nil, random)
+		if err != nil {
+			t.Fatal(err)
+		}
+		overhead := 1 + 1 + 4 + 8*3
+		if format == zstdwrap.FormatZstd1 {
+			overhead += 4
+		}
+		if len(frame) != len(random)+overhead {
+			t.Errorf("format %d: random frame is %d bytes, want stored size %d", format, len(frame), len(random)+overhead)
+		}
+	}
+}
+
+func TestStoredFrameWindow(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	src := make([]byte, 1<<20+1)
+	rnd.Read(src)
+
+	for _, size := range []int{0, 255, 256, 128 << 10, 128<<10 + 1, len(src)} {
+		frame, err := zstdwrap.StoredFrame(zstdwrap.FormatZstd1, src[:size])
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := zstdwrap.ReadFrameHeader(frame)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !hdr.HasContentSize || hdr.ContentSize != int64(size) {
+			t.Errorf("%d bytes: content size %d, %v", size, hdr.ContentSize, hdr.HasContentSize)
+		}
+		// The window is one block at most, not the
+		// whole content, however large the input.
+		if hdr.WindowSize > 128<<10 {
+			t.Errorf("%d bytes: window size %d, want at most 128kb", size, hdr.WindowSize)
+		}
+
+		// A Reader limited to a one-block window
+		// still decodes the frame.
+		r, err := zstdwrap.NewReader(bytes.NewReader(frame), 17)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, src[:size]) {
+			t.Errorf("%d bytes: round trip mismatch", size)
+		}
+	}
+}
+
+func TestAllowStoredReader(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	src := make([]byte, 4<<20)
+	rnd.Read(src)
+
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CompressionLevel: 1, AllowStored: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	frame, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The default Reader decodes the frame in its default
+	// window, as it does the frame compressed without
+	// AllowStored, and a Reader limited to 1mb does too.
+	for _, windowLogMax := range []int{0, 20} {
+		r, err := zstdwrap.NewReader(bytes.NewReader(frame), windowLogMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		_, err = io.Copy(&got, r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("windowLogMax %d: %v", windowLogMax, err)
+		}
+		if !bytes.Equal(got.Bytes(), src) {
+			t.Errorf("windowLogMax %d: round trip mismatch", windowLogMax)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #include "xxhash.h"
+import "C"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"unsafe"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrNoChecksum is reported by VerifyChecksum for
+// a frame compressed without COptions.Checksum.
+var ErrNoChecksum = errors.New("zstdwrap: frame has no checksum")
+
+// VerifyChecksum decodes the frames in src, discarding the content,
+// and reports whether the XXH64 checksum of each frame matches.
+//
+// Every frame must have a checksum, otherwise VerifyChecksum
+// reports ErrNoChecksum. Skippable frames are ignored.
+// Frames are decoded with the default window limit, see
+// NewDecompressor, in CStreamOutSize chunks.
+func VerifyChecksum(src []byte) (bool, error) {
+	sizes, rest, err := FrameSizes(src)
+	if err != nil {
+		return false, xerrors.Errorf("zstdwrap.VerifyChecksum: %w", err)
+	} else if rest != 0 {
+		return false, xerrors.Errorf("zstdwrap.VerifyChecksum: %w", ErrSrcSizeWrong)
+	} else if len(sizes) == 0 {
+		return false, errors.New("zstdwrap.VerifyChecksum: empty src")
+	}
+	for off, i := 0, 0; i < len(sizes); off, i = off+sizes[i], i+1 {
+		frame := src[off : off+sizes[i]]
+		if IsSkippableFrame(frame) {
+			continue
+		}
+		hdr, err := ReadFrameHeader(frame)
+		if err != nil {
+			return false, xerrors.Errorf("zstdwrap.VerifyChecksum: %w", err)
+		}
+		if !hdr.Checksum {
+			return false, ErrNoChecksum
+		}
+	}
+
+	r, err := NewReader(bytes.NewReader(src), 0)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	_, err = io.Copy(ioutil.Discard, r)
+	if xerrors.Is(err, ErrChecksumWrong) {
+		return false, nil
+	} else if err != nil {
+		return false, xerrors.Errorf("zstdwrap.VerifyChecksum: %w", err)
+	}
+	return true, nil
+}
+
+// RecompressWithChecksum decompresses src and compresses
+// its content at level into a frame with a content checksum.
+//
+// AddChecksum produces the same checksum without recompressing.
+func RecompressWithChecksum(src []byte, level int) ([]byte, error) {
+	content, err := Decompress(nil, src)
+	if err != nil {
+		return nil, xerrors.Errorf("zstdwrap.RecompressWithChecksum: %w", err)
+	}
+	c, err := NewCompressor(&COptions{CompressionLevel: level, Checksum: true})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Delete()
+	return c.Compress(nil, content)
+}
+
+// AddChecksum adds a content checksum to each frame in src
+// that lacks one, rewriting only frame metadata.
+//
+// The checksum is the low 32 bits of the XXH64 of the frame's
+// content, so each frame is still decompressed, but the
+// compressed blocks are copied unchanged. Frames that have
+// a checksum and skippable frames are copied as they are.
+func AddChecksum(src []byte) ([]byte, error) {
+	const checksumFlag = 1 << 2 // in the Frame_Header_Descriptor
+	out := make([]byte, 0, len(src)+16)
+	for len(src) > 0 {
+		n, err := FrameCompressedSize(src)
+		if err != nil {
+			return nil, xerrors.Errorf("zstdwrap.AddChecksum: %w", err)
+		}
+		frame := src[:n]
+		src = src[n:]
+		if IsSkippableFrame(frame) || frame[4]&checksumFlag != 0 {
+			out = append(out, frame...)
+			continue
+		}
+		content, err := Decompress(nil, frame)
+		if err != nil {
+			return nil, xerrors.Errorf("zstdwrap.AddChecksum: %w", err)
+		}
+		var contentv unsafe.Pointer
+		if len(content) > 0 {
+			contentv = unsafe.Pointer(&content[0])
+		}
+		sum := uint32(C.XXH64(contentv, C.size_t(len(content)), 0))
+
+		start := len(out)
+		out = append(out, frame...)
+		out[start+4] |= checksumFlag
+		out = append(out, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(out[len(out)-4:], sum)
+	}
+	return out, nil
+}
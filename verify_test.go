@@ -0,0 +1,139 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	src := []byte(strings.Repeat("stored blob. ", 1000))
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	frame, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := zstdwrap.VerifyChecksum(frame); err != nil || !ok {
+		t.Errorf("VerifyChecksum=%v, %v, want true", ok, err)
+	}
+	two := append(append([]byte{}, frame...), frame...)
+	if ok, err := zstdwrap.VerifyChecksum(two); err != nil || !ok {
+		t.Errorf("VerifyChecksum(two frames)=%v, %v, want true", ok, err)
+	}
+
+	badSum := append([]byte{}, frame...)
+	badSum[len(badSum)-1] ^= 0xff
+	if ok, err := zstdwrap.VerifyChecksum(badSum); err != nil || ok {
+		t.Errorf("VerifyChecksum(bad checksum)=%v, %v, want false", ok, err)
+	}
+
+	// A corrupted payload that still decodes
+	// is caught by the checksum.
+	badPayload := append([]byte{}, frame...)
+	hdr, err := zstdwrap.ReadFrameHeader(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := hdr.HeaderSize + 3; i < len(frame)-4; i++ {
+		badPayload[i] ^= 0x01
+		if _, err := zstdwrap.Decompress(nil, badPayload); xerrors.Is(err, zstdwrap.ErrChecksumWrong) {
+			break
+		}
+		badPayload[i] ^= 0x01
+	}
+	if ok, err := zstdwrap.VerifyChecksum(badPayload); err != nil || ok {
+		t.Errorf("VerifyChecksum(bad payload)=%v, %v, want false", ok, err)
+	}
+
+	plain, err := zstdwrap.Compress(nil, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zstdwrap.VerifyChecksum(plain); err != zstdwrap.ErrNoChecksum {
+		t.Errorf("VerifyChecksum(no checksum) err=%v, want ErrNoChecksum", err)
+	}
+}
+
+func TestAddChecksum(t *testing.T) {
+	src := []byte(strings.Repeat("stored without a checksum. ", 500))
+	plain, err := zstdwrap.Compress(nil, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skip, err := zstdwrap.WriteSkippableFrame(nil, 0, []byte("meta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := zstdwrap.Compress(nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := append(append(append([]byte{}, plain...), skip...), empty...)
+
+	recompressed, err := zstdwrap.RecompressWithChecksum(plain, 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := zstdwrap.AddChecksum(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != len(stream)+8 {
+		t.Errorf("AddChecksum added %d bytes, want 8", len(added)-len(stream))
+	}
+	again, err := zstdwrap.AddChecksum(added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, added) {
+		t.Error("AddChecksum modified frames that have a checksum")
+	}
+
+	for name, frame := range map[string][]byte{
+		"RecompressWithChecksum": recompressed,
+		"AddChecksum":            added[:len(plain)+4],
+	} {
+		hdr, err := zstdwrap.ReadFrameHeader(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.Checksum || frame[4]&(1<<2) == 0 {
+			t.Errorf("%s: checksum flag not set", name)
+		}
+		if ok, err := zstdwrap.VerifyChecksum(frame); err != nil || !ok {
+			t.Errorf("%s: VerifyChecksum=%v, %v", name, ok, err)
+		}
+		got, err := zstdwrap.Decompress(nil, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+	if ok, err := zstdwrap.VerifyChecksum(added); err != nil || !ok {
+		t.Errorf("AddChecksum stream: VerifyChecksum=%v, %v", ok, err)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #include "zstd.h"
+import "C"
+
+// Version reports the version of the linked zstd library
+// with ZSTD_versionNumber.
+func Version() (major, minor, patch int) {
+	v := int(C.ZSTD_versionNumber())
+	return v / (100 * 100), v / 100 % 100, v % 100
+}
+
+// VersionString reports the version of the linked zstd
+// library, such as "1.4.0", with ZSTD_versionString.
+func VersionString() string {
+	return C.GoString(C.ZSTD_versionString())
+}
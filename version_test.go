@@ -0,0 +1,32 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/crawshaw/zstdwrap"
+)
+
+func TestVersion(t *testing.T) {
+	major, minor, patch := zstdwrap.Version()
+	if major != 1 || minor < 4 {
+		t.Errorf("Version()=%d.%d.%d, want at least 1.4.0", major, minor, patch)
+	}
+	if got, want := zstdwrap.VersionString(), fmt.Sprintf("%d.%d.%d", major, minor, patch); got != want {
+		t.Errorf("VersionString()=%q, want %q", got, want)
+	}
+}
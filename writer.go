@@ -0,0 +1,402 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap
+
+// #define ZSTD_STATIC_LINKING_ONLY
+// #include "zstd.h"
+//
+// // zstdwrap_compressStream2 calls ZSTD_compressStream2 with buffers
+// // built on the C side, so no Go pointers are stored in C memory.
+// static size_t zstdwrap_compressStream2(ZSTD_CCtx* cctx,
+// 	void* dst, size_t dstSize, size_t* dstPos,
+// 	const void* src, size_t srcSize, size_t* srcPos,
+// 	ZSTD_EndDirective endOp) {
+// 	ZSTD_outBuffer out = { dst, dstSize, *dstPos };
+// 	ZSTD_inBuffer in = { src, srcSize, *srcPos };
+// 	size_t res = ZSTD_compressStream2(cctx, &out, &in, endOp);
+// 	*dstPos = out.pos;
+// 	*srcPos = in.pos;
+// 	return res;
+// }
+import "C"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"unsafe"
+
+	"golang.org/x/xerrors"
+)
+
+var errWriterClosed = errors.New("zstdwrap.Writer: write after Close")
+
+// Writer is an io.WriteCloser that compresses its input
+// into a zstd frame written to an underlying io.Writer.
+//
+// Writer is built on ZSTD_compressStream2.
+//
+// The bundled zstd predates ZSTD_c_stableInBuffer and
+// ZSTD_c_stableOutBuffer, so a Writer always copies its input
+// into zstd's window buffer and its output through a buffer of
+// CStreamOutSize. When the whole input is available at once,
+// Compressor.Compress avoids both copies.
+type Writer struct {
+	ctx    context.Context
+	w      io.Writer
+	c      *Compressor
+	in     []byte // ReadFrom buffer, allocated on first use
+	out    []byte // ZSTD_CStreamOutSize buffer
+	err    error  // sticky
+	closed bool
+	ended  bool // EndFrame was called, no frame is open
+
+	flushEvery int // COptions.FlushEvery
+	pending    int // bytes written since the last flush
+}
+
+// CStreamInSize reports the recommended size of the buffers passed
+// to Writer.Write, with ZSTD_CStreamInSize. It is one block.
+func CStreamInSize() int { return int(C.ZSTD_CStreamInSize()) }
+
+// CStreamOutSize reports the size of the buffer a Writer uses to
+// hold compressed output, with ZSTD_CStreamOutSize. It is enough
+// to hold one compressed block.
+func CStreamOutSize() int { return int(C.ZSTD_CStreamOutSize()) }
+
+// NewWriter creates a Writer that compresses into w.
+//
+// The Writer owns a Compressor configured with opts.
+// It is released by Close.
+func NewWriter(w io.Writer, opts *COptions) (*Writer, error) {
+	return NewWriterContext(context.Background(), w, opts)
+}
+
+// NewWriterContext is NewWriter with a context that aborts
+// compression.
+//
+// zstd cannot be interrupted, so ctx is checked between the
+// chunks passed to ZSTD_compressStream2. Once ctx is done, the
+// current Write, ReadFrom, Flush, EndFrame, or Close reports
+// ctx.Err(), the frame is abandoned, and the Compressor is
+// released.
+func NewWriterContext(ctx context.Context, w io.Writer, opts *COptions) (*Writer, error) {
+	var flushEvery int
+	if opts != nil {
+		flushEvery = opts.FlushEvery
+	}
+	if flushEvery < 0 {
+		return nil, xerrors.Errorf("zstdwrap.NewWriter: FlushEvery %d: %w", flushEvery, ErrParameterOutOfBound)
+	}
+	c, err := NewCompressor(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		ctx:        ctx,
+		w:          w,
+		c:          c,
+		out:        make([]byte, CStreamOutSize()),
+		flushEvery: flushEvery,
+	}, nil
+}
+
+// Write compresses p with ZSTD_e_continue.
+//
+// Compressed output is buffered and only written to the
+// underlying io.Writer when a block is ready.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.ended = false
+	n, w.err = w.stream(p, C.ZSTD_e_continue)
+	if w.err == nil {
+		w.err = w.autoFlush(n)
+	}
+	return n, w.err
+}
+
+// ReadFrom compresses data read from r until EOF, and returns
+// the number of bytes read. It implements io.ReaderFrom, so
+// io.Copy uses it to compress without an intermediate buffer.
+//
+// Data is read in CStreamInSize chunks. The frame is not ended.
+func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	if w.in == nil {
+		w.in = make([]byte, CStreamInSize())
+	}
+	for {
+		m, rerr := r.Read(w.in)
+		if m > 0 {
+			w.ended = false
+			n += int64(m)
+			if _, w.err = w.stream(w.in[:m], C.ZSTD_e_continue); w.err != nil {
+				return n, w.err
+			}
+			if w.err = w.autoFlush(m); w.err != nil {
+				return n, w.err
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		} else if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// Flush writes all data compressed so far to the underlying
+// io.Writer with ZSTD_e_flush.
+//
+// The frame is not ended, but a reader can decode everything
+// written before the Flush.
+//
+// Flush also ends the current block. The bundled zstd predates
+// ZSTD_c_targetCBlockSize, so flushing after each message is the
+// way to bound block size for latency-sensitive streams.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errWriterClosed
+	}
+	if w.ended {
+		// Nothing was written since EndFrame. Flushing
+		// would start a frame, which Close then ends.
+		return nil
+	}
+	w.pending = 0
+	_, w.err = w.stream(nil, C.ZSTD_e_flush)
+	return w.err
+}
+
+// autoFlush flushes if n more bytes bring the total written
+// since the last flush to COptions.FlushEvery.
+func (w *Writer) autoFlush(n int) error {
+	if w.flushEvery == 0 {
+		return nil
+	}
+	w.pending += n
+	if w.pending < w.flushEvery {
+		return nil
+	}
+	w.pending = 0
+	_, err := w.stream(nil, C.ZSTD_e_flush)
+	return err
+}
+
+// EndFrame completes the current frame with ZSTD_e_end and
+// writes it to the underlying io.Writer.
+//
+// Unlike Close, the Writer remains usable: the next Write
+// starts a new frame with the same options.
+func (w *Writer) EndFrame() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errWriterClosed
+	}
+	_, w.err = w.stream(nil, C.ZSTD_e_end)
+	w.ended = true
+	w.pending = 0
+	return w.err
+}
+
+// CompressReader compresses size bytes read from r into a
+// single frame, and returns it.
+//
+// The size is pledged, so it is recorded in the frame header
+// and the content does not need to be buffered by the caller.
+// Data is read in CStreamInSize chunks, as by Writer.ReadFrom.
+// Reading stops after size bytes. If r ends before then,
+// CompressReader reports io.ErrUnexpectedEOF.
+func CompressReader(r io.Reader, size int64, opts *COptions) ([]byte, error) {
+	if size < 0 {
+		return nil, xerrors.Errorf("zstdwrap.CompressReader: size %d: %w", size, ErrSrcSizeWrong)
+	}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	if err := w.SetPledgedSrcSize(size); err != nil {
+		return nil, err
+	}
+	n, err := w.ReadFrom(io.LimitReader(r, size))
+	if err != nil {
+		return nil, err
+	} else if n < size {
+		return nil, xerrors.Errorf("zstdwrap.CompressReader: read %d of %d bytes: %w", n, size, io.ErrUnexpectedEOF)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CompressLarge compresses src into a single frame with opts,
+// like Compressor.Compress, but without allocating
+// CompressBound(len(src)) up front.
+//
+// The frame is produced by ZSTD_compressStream2 in CStreamOutSize
+// chunks and collected in a buffer that grows with the output,
+// so memory is proportional to the compressed size. This matters
+// for inputs of many gigabytes that compress well.
+func CompressLarge(src []byte, opts *COptions) ([]byte, error) {
+	c, err := NewCompressor(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Delete()
+
+	var srcv unsafe.Pointer
+	if len(src) > 0 {
+		srcv = unsafe.Pointer(&src[0])
+	}
+	buf := new(bytes.Buffer)
+	out := make([]byte, CStreamOutSize())
+	var srcPos C.size_t
+	for {
+		// With all of src in the first ZSTD_e_end call, zstd
+		// records its size in the frame header, as Compress does.
+		var dstPos C.size_t
+		res := C.zstdwrap_compressStream2(c.ctx,
+			unsafe.Pointer(&out[0]), C.size_t(len(out)), &dstPos,
+			srcv, C.size_t(len(src)), &srcPos,
+			C.ZSTD_e_end)
+		if err := isErr("CompressLarge", res); err != nil {
+			return nil, err
+		}
+		buf.Write(out[:dstPos])
+		if res == 0 {
+			return buf.Bytes(), nil
+		}
+	}
+}
+
+// SetPledgedSrcSize declares the total size of the frame.
+// It must be called before the first Write of a frame.
+// See Compressor.SetPledgedSrcSize.
+func (w *Writer) SetPledgedSrcSize(n int64) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	return w.c.SetPledgedSrcSize(n)
+}
+
+// SetParameter sets a compression parameter for the next
+// frame. It is meant to be called after EndFrame.
+// See Compressor.SetParameter.
+func (w *Writer) SetParameter(p CParameter, value int) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	return w.c.SetParameter(p, value)
+}
+
+// Progression reports the progress of the current frame.
+// See Compressor.Progression.
+func (w *Writer) Progression() FrameProgression {
+	if w.closed {
+		return FrameProgression{}
+	}
+	return w.c.Progression()
+}
+
+// Close completes the frame with ZSTD_e_end, writes it to the
+// underlying io.Writer, and releases the Writer's Compressor.
+// If EndFrame was called and nothing written since, Close does
+// not start another frame.
+//
+// Close does not close the underlying io.Writer.
+// Calling Close more than once is safe.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err == nil && !w.ended {
+		_, w.err = w.stream(nil, C.ZSTD_e_end)
+	}
+	if err := w.c.Delete(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// stream passes src through ZSTD_compressStream2 with endOp,
+// writing any compressed output to the underlying io.Writer.
+//
+// For ZSTD_e_continue it returns when all of src is consumed.
+// For ZSTD_e_flush and ZSTD_e_end it returns once zstd reports
+// its internal buffers are empty.
+func (w *Writer) stream(src []byte, endOp C.ZSTD_EndDirective) (n int, err error) {
+	var srcv unsafe.Pointer
+	if len(src) > 0 {
+		srcv = unsafe.Pointer(&src[0])
+	}
+	var srcPos C.size_t
+	for {
+		if err := w.ctx.Err(); err != nil {
+			w.closed = true
+			w.c.Delete()
+			return int(srcPos), err
+		}
+		var dstPos C.size_t
+		res := C.zstdwrap_compressStream2(w.c.ctx,
+			unsafe.Pointer(&w.out[0]), C.size_t(len(w.out)), &dstPos,
+			srcv, C.size_t(len(src)), &srcPos,
+			endOp)
+		if err := isErr("Writer", res); err != nil {
+			return int(srcPos), err
+		}
+		if dstPos > 0 {
+			if err := w.writeOut(w.out[:dstPos]); err != nil {
+				return int(srcPos), err
+			}
+		}
+		if endOp == C.ZSTD_e_continue {
+			if int(srcPos) == len(src) {
+				return int(srcPos), nil
+			}
+		} else if res == 0 {
+			return int(srcPos), nil
+		}
+	}
+}
+
+func (w *Writer) writeOut(b []byte) error {
+	n, err := w.w.Write(b)
+	if err == nil && n < len(b) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
@@ -0,0 +1,531 @@
+// Copyright (c) 2019 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package zstdwrap_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/crawshaw/zstdwrap"
+	"golang.org/x/xerrors"
+)
+
+func TestWriter(t *testing.T) {
+	src := strings.Repeat("Hello, World!\n", 2000)
+
+	t.Run("Close", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(src); i += 100 {
+			if _, err := io.WriteString(w, src[i:i+100]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("second Close: %v", err)
+		}
+		if _, err := w.Write([]byte("x")); err == nil {
+			t.Error("Write after Close succeeded")
+		}
+
+		sz, err := zstdwrap.FrameCompressedSize(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sz != buf.Len() {
+			t.Errorf("FrameCompressedSize=%d, want %d", sz, buf.Len())
+		}
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		if _, err := io.WriteString(w, src); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() == 0 {
+			t.Error("Flush wrote nothing")
+		}
+	})
+
+	t.Run("FlushEvery", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, &zstdwrap.COptions{FlushEvery: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		if _, err := io.WriteString(w, src[:600]); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("below FlushEvery wrote %d bytes", buf.Len())
+		}
+		if _, err := io.WriteString(w, src[600:1200]); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() == 0 {
+			t.Fatal("crossing FlushEvery wrote nothing")
+		}
+
+		// The flushed prefix decodes without the end of the frame.
+		r, err := zstdwrap.NewReader(bytes.NewReader(buf.Bytes()), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got := make([]byte, 1200)
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src[:1200] {
+			t.Error("flushed prefix mismatch")
+		}
+
+		// The count restarts after each flush.
+		n := buf.Len()
+		if _, err := io.WriteString(w, src[1200:1800]); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != n {
+			t.Errorf("below FlushEvery after a flush wrote %d bytes", buf.Len()-n)
+		}
+
+		if _, err := zstdwrap.NewWriter(new(bytes.Buffer), &zstdwrap.COptions{FlushEvery: -1}); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+			t.Errorf("negative FlushEvery: err=%v, want ErrParameterOutOfBound", err)
+		}
+	})
+
+	t.Run("SetPledgedSrcSize", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetPledgedSrcSize(int64(len(src))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, src); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sz, err := zstdwrap.FrameContentSize(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sz != int64(len(src)) {
+			t.Errorf("FrameContentSize=%d, want %d", sz, len(src))
+		}
+	})
+
+	t.Run("SetPledgedSrcSize-ErrSrcSizeWrong", func(t *testing.T) {
+		w, err := zstdwrap.NewWriter(ioutil.Discard, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetPledgedSrcSize(int64(len(src)) + 1); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, src)
+		if err := w.Close(); !xerrors.Is(err, zstdwrap.ErrSrcSizeWrong) {
+			t.Errorf("Close err=%v, want ErrSrcSizeWrong", err)
+		}
+	})
+
+	t.Run("ShortWrite", func(t *testing.T) {
+		w, err := zstdwrap.NewWriter(shortWriter{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, src)
+		if err := w.Close(); err != io.ErrShortWrite {
+			t.Errorf("Close err=%v, want io.ErrShortWrite", err)
+		}
+	})
+
+	t.Run("Progression", func(t *testing.T) {
+		for _, opts := range []*zstdwrap.COptions{nil, {NBWorkers: 2}} {
+			buf := new(bytes.Buffer)
+			w, err := zstdwrap.NewWriter(buf, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			if _, err := io.WriteString(w, src); err != nil {
+				t.Fatal(err)
+			}
+			p := w.Progression()
+			if p.Ingested != int64(len(src)) {
+				t.Errorf("%+v: Ingested=%d, want %d", opts, p.Ingested, len(src))
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			p = w.Progression()
+			if p.Consumed != int64(len(src)) || p.Produced == 0 {
+				t.Errorf("%+v: after Flush Consumed=%d, Produced=%d, want %d, >0", opts, p.Consumed, p.Produced, len(src))
+			}
+			if p.FlushedSize == 0 || p.FlushedSize > p.Produced {
+				t.Errorf("%+v: after Flush FlushedSize=%d, want in (0, %d]", opts, p.FlushedSize, p.Produced)
+			}
+		}
+	})
+
+	t.Run("ReadFrom", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(w, iotest.HalfReader(strings.NewReader(src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(src)) {
+			t.Errorf("io.Copy n=%d, want %d", n, len(src))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := zstdwrap.Decompress(nil, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src {
+			t.Error("round trip mismatch")
+		}
+
+		w, err = zstdwrap.NewWriter(new(bytes.Buffer), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		if _, err := w.ReadFrom(iotest.TimeoutReader(strings.NewReader(src))); err != iotest.ErrTimeout {
+			t.Errorf("ReadFrom err=%v, want %v", err, iotest.ErrTimeout)
+		}
+	})
+
+	t.Run("EndFrame", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs := []string{"first frame", src}
+		for _, msg := range msgs {
+			if _, err := io.WriteString(w, msg); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.EndFrame(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// A Flush with nothing written since EndFrame
+		// must not start another frame.
+		n := buf.Len()
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != n {
+			t.Errorf("Flush after EndFrame wrote %d bytes", buf.Len()-n)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stream := buf.Bytes()
+		sizes, rest, err := zstdwrap.FrameSizes(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sizes) != 2 || rest != 0 {
+			t.Fatalf("FrameSizes=%v, %d, want 2 frames", sizes, rest)
+		}
+		for i, msg := range msgs {
+			got, err := zstdwrap.Decompress(nil, stream[:sizes[i]])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != msg {
+				t.Errorf("frame %d has %d bytes, want %d", i, len(got), len(msg))
+			}
+			stream = stream[sizes[i]:]
+		}
+	})
+
+	t.Run("SetParameter", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, &zstdwrap.COptions{CompressionLevel: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, src); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetParameter(zstdwrap.CParamChecksumFlag, 1); !xerrors.Is(err, zstdwrap.ErrStageWrong) {
+			t.Errorf("SetParameter mid-frame: err=%v, want ErrStageWrong", err)
+		}
+		if err := w.EndFrame(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetParameter(zstdwrap.CParamCompressionLevel, 19); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetParameter(zstdwrap.CParamChecksumFlag, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, src); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stream := buf.Bytes()
+		sizes, _, err := zstdwrap.FrameSizes(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sizes) != 2 {
+			t.Fatalf("got %d frames, want 2", len(sizes))
+		}
+		for i, wantChecksum := range []bool{false, true} {
+			frame := stream[:sizes[i]]
+			stream = stream[sizes[i]:]
+			hdr, err := zstdwrap.ReadFrameHeader(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Checksum != wantChecksum {
+				t.Errorf("frame %d: Checksum=%v, want %v", i, hdr.Checksum, wantChecksum)
+			}
+			got, err := zstdwrap.Decompress(nil, frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != src {
+				t.Errorf("frame %d: round trip mismatch", i)
+			}
+		}
+	})
+}
+
+func TestCompressReader(t *testing.T) {
+	src := strings.Repeat("Hello, World!\n", 20000)
+
+	frame, err := zstdwrap.CompressReader(iotest.HalfReader(strings.NewReader(src)), int64(len(src)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := zstdwrap.FrameContentSize(frame); err != nil || size != int64(len(src)) {
+		t.Errorf("FrameContentSize=%d, %v, want %d", size, err, len(src))
+	}
+	got, err := zstdwrap.Decompress(nil, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != src {
+		t.Error("round trip mismatch")
+	}
+
+	// Only size bytes are read.
+	r := strings.NewReader(src)
+	frame, err = zstdwrap.CompressReader(iotest.HalfReader(r), 100, &zstdwrap.COptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := zstdwrap.Decompress(nil, frame); err != nil || string(got) != src[:100] {
+		t.Errorf("prefix: got %q, %v", got, err)
+	}
+	if r.Len() != len(src)-100 {
+		t.Errorf("read %d bytes, want 100", len(src)-r.Len())
+	}
+
+	if frame, err := zstdwrap.CompressReader(strings.NewReader(""), 0, nil); err != nil {
+		t.Fatal(err)
+	} else if got, err := zstdwrap.Decompress(nil, frame); err != nil || len(got) != 0 {
+		t.Errorf("empty: got %q, %v", got, err)
+	}
+
+	_, err = zstdwrap.CompressReader(iotest.HalfReader(strings.NewReader(src)), int64(len(src))+1, nil)
+	if !xerrors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("short reader: err=%v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestCompressLarge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	big := make([]byte, 3<<20)
+	for i := range big {
+		big[i] = byte('a' + rnd.Intn(4))
+	}
+	for _, test := range []struct {
+		name string
+		src  []byte
+		opts *zstdwrap.COptions
+	}{
+		{"empty", nil, nil},
+		{"small", []byte("Hello, World!"), nil},
+		{"big", big, nil},
+		{"level19-checksum", big[:256<<10], &zstdwrap.COptions{CompressionLevel: 19, Checksum: true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := zstdwrap.NewCompressor(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Delete()
+			want, err := c.Compress(nil, test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := zstdwrap.CompressLarge(test.src, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("CompressLarge produced %d bytes, Compress %d, frames differ", len(got), len(want))
+			}
+		})
+	}
+}
+
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) { return len(p) / 2, nil }
+
+func TestStreamSizes(t *testing.T) {
+	const block = 128 << 10 // ZSTD_BLOCKSIZE_MAX
+	for _, s := range []struct {
+		name string
+		n    int
+	}{
+		{"CStreamInSize", zstdwrap.CStreamInSize()},
+		{"CStreamOutSize", zstdwrap.CStreamOutSize()},
+		{"DStreamInSize", zstdwrap.DStreamInSize()},
+		{"DStreamOutSize", zstdwrap.DStreamOutSize()},
+	} {
+		if s.n < block || s.n > 2*block {
+			t.Errorf("%s()=%d, want about %d", s.name, s.n, block)
+		}
+	}
+}
+
+// BenchmarkWriterSingleShot measures the buffer copies a Writer
+// makes, which Compress avoids, on one large write.
+func BenchmarkWriterSingleShot(b *testing.B) {
+	src := []byte(strings.Repeat("a large single-shot streaming write. ", 1<<15))
+	b.Run("Writer", func(b *testing.B) {
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			w, err := zstdwrap.NewWriter(ioutil.Discard, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := w.Write(src); err != nil {
+				b.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Compress", func(b *testing.B) {
+		dst := make([]byte, zstdwrap.CompressBound(len(src)))
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			c, err := zstdwrap.NewCompressor(nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := c.Compress(dst[:0], src); err != nil {
+				b.Fatal(err)
+			}
+			c.Delete()
+		}
+	})
+}
+
+// cancelWriter cancels a context after its first Write.
+type cancelWriter struct {
+	cancel func()
+	n      int
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	w.cancel()
+	return len(p), nil
+}
+
+func TestWriterContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cw := &cancelWriter{cancel: cancel}
+	w, err := zstdwrap.NewWriterContext(ctx, cw, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(src)
+	n, err := w.Write(src)
+	if !xerrors.Is(err, context.Canceled) {
+		t.Fatalf("Write err=%v, want context.Canceled", err)
+	}
+	if n == 0 || n == len(src) {
+		t.Errorf("Write consumed %d of %d bytes, want part", n, len(src))
+	}
+	if _, err := w.Write(src); !xerrors.Is(err, context.Canceled) {
+		t.Errorf("second Write err=%v, want context.Canceled", err)
+	}
+	if err := w.Close(); !xerrors.Is(err, context.Canceled) {
+		t.Errorf("Close err=%v, want context.Canceled", err)
+	}
+	if cw.n == 0 {
+		t.Error("nothing written before cancellation")
+	}
+
+	// A context done before the first Write fails at once.
+	w, err = zstdwrap.NewWriterContext(ctx, ioutil.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello")); !xerrors.Is(err, context.Canceled) {
+		t.Errorf("Write with done context err=%v, want context.Canceled", err)
+	}
+	w.Close()
+}
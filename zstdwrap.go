@@ -16,9 +16,13 @@
 //
 // The goal is not to implement a typical Go compression API
 // of io.Reader and io.Writer. Instead this package is nothing
-// more than type-safe primitives.
+// more than type-safe primitives. Reader and Writer are thin
+// adapters over the zstd streaming API for when the data is
+// not available as a single buffer.
 //
-// TODO: streaming interface
+// A Compressor or Decompressor must not be used by more than one
+// goroutine at a time. Building with the zstdwrap_debug tag makes
+// concurrent use panic rather than corrupt the zstd context.
 package zstdwrap
 
 // #cgo CFLAGS: -DZSTD_MULTITHREAD
@@ -32,19 +36,262 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"golang.org/x/xerrors"
 )
 
 type COptions struct {
-	CompressionLevel int // 1-22, default 3, caution using levels >= 20
+	// CompressionLevel is between MinCompressionLevel and
+	// MaxCompressionLevel. Negative levels trade compression
+	// ratio for speed. Use caution with levels >= 20, they need
+	// a lot of memory.
+	//
+	// Zero selects DefaultCompressionLevel. zstd itself treats
+	// level 0 as "use the default", so there is no way to ask
+	// for a distinct level 0.
+	CompressionLevel int
 	Checksum         bool
-	// TODO dictionary
+
+	// ContentSizeFlag explicitly sets ZSTD_c_contentSizeFlag, so
+	// the frame header records the content size whenever it is
+	// known. Compress always knows it. A Writer only knows it
+	// after SetPledgedSrcSize. False leaves the zstd default,
+	// which is also to record the size.
+	ContentSizeFlag bool
+
+	// NoContentSize clears ZSTD_c_contentSizeFlag, so frame
+	// headers omit the content size and FrameContentSize reports
+	// ErrContentSizeUnknown. It saves up to 8 bytes per frame.
+	// Decompress still decodes such frames, by streaming.
+	// ContentSizeFlag and NoContentSize are exclusive.
+	NoContentSize bool
+
+	// DictIDFlag explicitly sets ZSTD_c_dictIDFlag, so frames
+	// compressed with a dictionary record its ID, which
+	// GetDictIDFromFrame reports. False leaves the zstd default,
+	// which is also to record the ID.
+	DictIDFlag bool
+
+	// Format selects the frame format. A Decompressor
+	// must use the same format to decode the frames.
+	Format Format
+
+	// Advanced compression parameters.
+	// Zero leaves a parameter at the zstd default,
+	// which is derived from the CompressionLevel.
+	WindowLog    int // log2 of the maximum back-reference distance
+	HashLog      int // log2 of the initial probe table size
+	ChainLog     int // log2 of the multi-probe search table size
+	SearchLog    int // log2 of the number of search attempts
+	MinMatch     int // minimum size of searched matches
+	TargetLength int // meaning depends on Strategy
+	Strategy     Strategy
+
+	// LiteralCompressionMode selects whether literals, the
+	// bytes not covered by a match, are Huffman coded.
+	// LiteralUncompressed saves CPU time on data that is
+	// already compressed or encrypted.
+	LiteralCompressionMode LiteralCompressionMode
+
+	// EnableLongDistanceMatching finds matches far back in large
+	// inputs. It increases the default WindowLog to 27 (128mb),
+	// so decompressing may need a larger windowLogMax.
+	// Zero values for the LDM parameters are chosen by zstd.
+	EnableLongDistanceMatching bool
+	LDMHashLog                 int // log2 of the LDM hash table size
+	LDMMinMatch                int // minimum LDM match size
+	LDMBucketSizeLog           int // log2 of each LDM hash table bucket size
+	LDMHashRateLog             int // log2 of the LDM table insertion frequency
+
+	// NBWorkers is the number of threads zstd uses to compress.
+	// Zero compresses in the calling goroutine.
+	// With workers, Writer calls return before compression is
+	// complete and Compress splits large inputs into jobs.
+	NBWorkers  int
+	JobSize    int // bytes per job when NBWorkers > 0, at least 1mb
+	OverlapLog int // 1 (no overlap) to 9 (full window) when NBWorkers > 0
+
+	// MaxMemory, if positive, bounds the estimated memory of the
+	// NBWorkers jobs, in bytes. NewCompressor reduces NBWorkers,
+	// down to zero if need be, until the workers fit.
+	// EstimateWorkers reports the number used.
+	MaxMemory int64
+
+	// SkipCompressionBelow stores inputs to Compress shorter
+	// than this many bytes uncompressed, in a frame built
+	// without calling zstd. For tiny inputs the cost of the cgo
+	// call outweighs what compression saves. The output is
+	// still a valid frame.
+	//
+	// Inputs are always compressed when checksums are enabled
+	// or a RefPrefix is pending. It must be at most 128kb,
+	// the maximum block size.
+	SkipCompressionBelow int
+
+	// AllowStored makes Compress emit a frame of raw blocks when
+	// zstd's frame is larger. zstd already stores incompressible
+	// blocks raw, so this mostly trims frame header bytes, but it
+	// guarantees the frame is at most len(src) plus a header of
+	// up to 14 bytes and 3 bytes per 128kb block.
+	// It does not apply when checksums are enabled.
+	AllowStored bool
+
+	// Rsyncable adds synchronization points to the compressed
+	// output, so a small edit to the input changes only a small
+	// part of the output. It costs a little compression ratio.
+	// Rsyncable only has an effect when NBWorkers > 0.
+	// Points are placed about every JobSize/2 bytes.
+	Rsyncable bool
+
+	// FlushEvery makes a Writer flush, as by Writer.Flush, each
+	// time at least this many uncompressed bytes have been
+	// written since the last flush. Without it, small writes can
+	// sit in zstd's buffers until a block fills, so a reader of
+	// an interactive stream would not see them. Each flush ends
+	// a block, which costs some compression ratio.
+	// It has no effect on a Compressor used directly.
+	FlushEvery int
+
+	// Dictionary is loaded into the Compressor with
+	// ZSTD_CCtx_loadDictionary and used for every frame it
+	// compresses. The bytes are copied, so the slice need
+	// not outlive NewCompressor.
+	//
+	// Frames compressed with a dictionary can only be
+	// decompressed with the same dictionary.
+	Dictionary []byte
+
+	// DictionaryByReference loads Dictionary with
+	// ZSTD_CCtx_loadDictionary_byReference, so zstd does not
+	// copy it into the context. C code cannot keep a reference
+	// to Go memory, so the bytes are copied once into C memory
+	// instead, which is freed when the dictionary is replaced or
+	// the Compressor is deleted. Clones share that copy. To share
+	// a dictionary between unrelated Compressors, use CDict.
+	DictionaryByReference bool
+
+	// CDict is a digested dictionary referenced with
+	// ZSTD_CCtx_refCDict. It is cheaper than Dictionary
+	// when many Compressors share a dictionary.
+	// Dictionary and CDict cannot both be set.
+	CDict *CDict
+
+	// FreeOnFinalize sets a finalizer that frees the zstd context
+	// if the Compressor is garbage collected without Delete.
+	// Finalizers run at an unpredictable time, if at all, and a
+	// context holds C memory the Go collector does not see, so
+	// calling Delete remains the way to release a Compressor.
+	// Builds with the zstdwrap_debug tag log each such leak.
+	FreeOnFinalize bool
 }
 
+// MinCompressionLevel reports the fastest compression level
+// supported by zstd, with ZSTD_minCLevel.
+func MinCompressionLevel() int { return int(C.ZSTD_minCLevel()) }
+
+// MaxCompressionLevel reports the strongest compression level
+// supported by zstd, with ZSTD_maxCLevel.
+func MaxCompressionLevel() int { return int(C.ZSTD_maxCLevel()) }
+
+// DefaultCompressionLevel reports the compression level used
+// when COptions.CompressionLevel is zero.
+func DefaultCompressionLevel() int { return C.ZSTD_CLEVEL_DEFAULT }
+
+// WindowLogMin reports the smallest window log a frame can use,
+// ZSTD_WINDOWLOG_MIN.
+func WindowLogMin() int { return C.ZSTD_WINDOWLOG_MIN }
+
+// WindowLogMax reports the largest window log zstd supports on
+// this platform, ZSTD_WINDOWLOG_MAX.
+func WindowLogMax() int { return C.ZSTD_WINDOWLOG_MAX }
+
+// WindowLogLimitDefault reports the window log limit a
+// Decompressor uses by default, ZSTD_WINDOWLOG_LIMIT_DEFAULT.
+// Frames with a larger window are refused unless the limit
+// is raised with DOptions.
+func WindowLogLimitDefault() int { return C.ZSTD_WINDOWLOG_LIMIT_DEFAULT }
+
+// checkLevel reports ErrParameterOutOfBound if level is
+// not a supported compression level.
+//
+// zstd clamps levels out of range, which hides mistakes.
+func checkLevel(loc string, level int) error {
+	if level < MinCompressionLevel() || level > MaxCompressionLevel() {
+		return xerrors.Errorf("zstdwrap.%s: compression level %d not in [%d, %d]: %w", loc, level, MinCompressionLevel(), MaxCompressionLevel(), ErrParameterOutOfBound)
+	}
+	return nil
+}
+
+// Format is a zstd frame format.
+type Format int
+
+const (
+	// FormatZstd1 is the RFC 8478 frame format.
+	FormatZstd1 Format = C.ZSTD_f_zstd1
+
+	// FormatZstd1Magicless omits the 4-byte magic number
+	// that starts each frame. It is useful when frames are
+	// embedded in a container that does its own framing.
+	FormatZstd1Magicless Format = C.ZSTD_f_zstd1_magicless
+)
+
+// Strategy is a zstd compression strategy.
+// Strategies are listed from fastest to strongest.
+type Strategy int
+
+const (
+	StrategyFast     Strategy = C.ZSTD_fast
+	StrategyDFast    Strategy = C.ZSTD_dfast
+	StrategyGreedy   Strategy = C.ZSTD_greedy
+	StrategyLazy     Strategy = C.ZSTD_lazy
+	StrategyLazy2    Strategy = C.ZSTD_lazy2
+	StrategyBTLazy2  Strategy = C.ZSTD_btlazy2
+	StrategyBTOpt    Strategy = C.ZSTD_btopt
+	StrategyBTUltra  Strategy = C.ZSTD_btultra
+	StrategyBTUltra2 Strategy = C.ZSTD_btultra2
+)
+
+// LiteralCompressionMode is a zstd literal compression mode.
+type LiteralCompressionMode int
+
+const (
+	// LiteralAuto lets zstd decide from the compression level.
+	// Negative levels leave literals uncompressed.
+	LiteralAuto LiteralCompressionMode = C.ZSTD_lcm_auto
+
+	// LiteralHuffman always tries Huffman coding. Literals are
+	// still stored raw when coding does not make them smaller.
+	LiteralHuffman LiteralCompressionMode = C.ZSTD_lcm_huffman
+
+	// LiteralUncompressed always stores literals raw.
+	LiteralUncompressed LiteralCompressionMode = C.ZSTD_lcm_uncompressed
+)
+
 type Compressor struct {
-	ctx *C.ZSTD_CCtx
+	ctx     *C.ZSTD_CCtx
+	cdict   *CDict
+	prefix  unsafe.Pointer // C copy of the RefPrefix bytes
+	dictRef *cBytes        // referenced by zstd, see DictionaryByReference
+	dict    []byte         // copy of a loaded Dictionary, for Clone
+	dictCT  DictContentType
+	blocks  blockHistory
+	guard   useGuard
+	scratch []byte // CompressedSize output, reused
+
+	// Tracked for storedFrame, see COptions.SkipCompressionBelow
+	// and AllowStored.
+	skipBelow     int
+	allowStored   bool
+	checksum      bool
+	noContentSize bool
+	format        Format
 }
 
 func NewCompressor(opts *COptions) (*Compressor, error) {
@@ -55,32 +302,357 @@ func NewCompressor(opts *COptions) (*Compressor, error) {
 		return nil, fmt.Errorf("zstdwrap: ZSTD_createCCtx failed")
 	}
 	if opts != nil {
-		if l := opts.CompressionLevel; l != 0 {
-			res := C.ZSTD_CCtx_setParameter(c.ctx, C.ZSTD_c_compressionLevel, C.int(l))
-			if err := isErr("NewCompressor(level)", res); err != nil {
-				return nil, err
-			}
+		if err := c.setOptions(opts); err != nil {
+			c.Delete()
+			return nil, err
 		}
-		if opts.Checksum {
-			res := C.ZSTD_CCtx_setParameter(c.ctx, C.ZSTD_c_checksumFlag, 1)
-			if err := isErr("NewCompressor(checksum)", res); err != nil {
-				return nil, err
-			}
+		if opts.FreeOnFinalize {
+			runtime.SetFinalizer(c, (*Compressor).finalize)
 		}
 	}
 	return c, nil
 }
 
+func (c *Compressor) setOptions(opts *COptions) error {
+	if err := checkLevel("NewCompressor", opts.CompressionLevel); err != nil {
+		return err
+	}
+	if n := opts.SkipCompressionBelow; n < 0 || n > maxStored {
+		return xerrors.Errorf("zstdwrap.NewCompressor: SkipCompressionBelow %d: %w", n, ErrParameterOutOfBound)
+	}
+	c.skipBelow = opts.SkipCompressionBelow
+	c.allowStored = opts.AllowStored
+	if opts.MaxMemory < 0 {
+		return xerrors.Errorf("zstdwrap.NewCompressor: MaxMemory %d: %w", opts.MaxMemory, ErrParameterOutOfBound)
+	}
+	checksum := 0
+	if opts.Checksum {
+		checksum = 1
+	}
+	if opts.ContentSizeFlag && opts.NoContentSize {
+		return errors.New("zstdwrap.NewCompressor: ContentSizeFlag and NoContentSize are exclusive")
+	}
+	contentSize := 0
+	if opts.ContentSizeFlag {
+		contentSize = 1
+	}
+	dictID := 0
+	if opts.DictIDFlag {
+		dictID = 1
+	}
+	ldm := 0
+	if opts.EnableLongDistanceMatching {
+		ldm = 1
+	}
+	rsyncable := 0
+	if opts.Rsyncable {
+		rsyncable = 1
+	}
+	params := []struct {
+		name  string
+		param CParameter
+		value int
+	}{
+		{"level", CParamCompressionLevel, opts.CompressionLevel},
+		{"checksum", CParamChecksumFlag, checksum},
+		{"contentsize", CParamContentSizeFlag, contentSize},
+		{"dictid", CParamDictIDFlag, dictID},
+		{"format", CParamFormat, int(opts.Format)},
+		{"windowlog", CParamWindowLog, opts.WindowLog},
+		{"hashlog", CParamHashLog, opts.HashLog},
+		{"chainlog", CParamChainLog, opts.ChainLog},
+		{"searchlog", CParamSearchLog, opts.SearchLog},
+		{"minmatch", CParamMinMatch, opts.MinMatch},
+		{"targetlength", CParamTargetLength, opts.TargetLength},
+		{"strategy", CParamStrategy, int(opts.Strategy)},
+		{"literalcompressionmode", CParamLiteralCompressionMode, int(opts.LiteralCompressionMode)},
+		{"ldm", CParamEnableLongDistanceMatching, ldm},
+		{"ldmhashlog", CParamLDMHashLog, opts.LDMHashLog},
+		{"ldmminmatch", CParamLDMMinMatch, opts.LDMMinMatch},
+		{"ldmbucketsizelog", CParamLDMBucketSizeLog, opts.LDMBucketSizeLog},
+		{"ldmhashratelog", CParamLDMHashRateLog, opts.LDMHashRateLog},
+		{"nbworkers", CParamNBWorkers, EstimateWorkers(opts)},
+		{"jobsize", CParamJobSize, opts.JobSize},
+		{"overlaplog", CParamOverlapLog, opts.OverlapLog},
+		{"rsyncable", CParamRsyncable, rsyncable},
+	}
+	for _, p := range params {
+		if p.value == 0 {
+			continue
+		}
+		if err := c.setParameter("NewCompressor("+p.name+")", p.param, p.value); err != nil {
+			return err
+		}
+	}
+	if opts.NoContentSize {
+		if err := c.setParameter("NewCompressor(contentsize)", CParamContentSizeFlag, 0); err != nil {
+			return err
+		}
+	}
+
+	// Loaded last: compression parameters cannot
+	// be changed after loading a dictionary.
+	if len(opts.Dictionary) > 0 && opts.CDict != nil {
+		return errors.New("zstdwrap.NewCompressor: Dictionary and CDict are exclusive")
+	}
+	if dict := opts.Dictionary; len(dict) > 0 {
+		// zstd digests the dictionary on first use.
+		if err := checkDict("NewCompressor(dictionary)", dict); err != nil {
+			return err
+		}
+		lm := DictLoadByCopy
+		if opts.DictionaryByReference {
+			lm = DictLoadByRef
+		}
+		return c.loadDictionary("NewCompressor(dictionary)", dict, DictContentAuto, lm)
+	}
+	if opts.CDict != nil {
+		return c.refCDict("NewCompressor(cdict)", opts.CDict)
+	}
+	return nil
+}
+
+// loadDictionary loads dict into the Compressor with
+// ZSTD_CCtx_loadDictionary_advanced. An empty dict
+// removes the dictionary.
+func (c *Compressor) loadDictionary(loc string, dict []byte, ct DictContentType, lm DictLoadMethod) error {
+	if lm == DictLoadByRef && len(dict) > 0 {
+		ref := newCBytes(dict)
+		defer releaseCBytes(&ref)
+		return c.loadDictionaryRef(loc, ref, ct)
+	}
+	var dictv unsafe.Pointer
+	if len(dict) > 0 {
+		dictv = unsafe.Pointer(&dict[0])
+	}
+	res := C.ZSTD_CCtx_loadDictionary_advanced(c.ctx, dictv, C.size_t(len(dict)),
+		C.ZSTD_dlm_byCopy, C.ZSTD_dictContentType_e(ct))
+	if err := isErr(loc, res); err != nil {
+		return err
+	}
+	c.clearDict()
+	c.dictCT = ct
+	if len(dict) > 0 {
+		c.dict = append([]byte(nil), dict...)
+	}
+	return nil
+}
+
+// loadDictionaryRef loads ref into the Compressor with
+// ZSTD_dlm_byRef. The Compressor holds a reference to ref
+// until the dictionary is replaced.
+func (c *Compressor) loadDictionaryRef(loc string, ref *cBytes, ct DictContentType) error {
+	res := C.ZSTD_CCtx_loadDictionary_advanced(c.ctx, ref.p, C.size_t(ref.n),
+		C.ZSTD_dlm_byRef, C.ZSTD_dictContentType_e(ct))
+	if err := isErr(loc, res); err != nil {
+		return err
+	}
+	c.clearDict()
+	c.dictRef, c.dictCT = ref.acquire(), ct
+	return nil
+}
+
+func (c *Compressor) refCDict(loc string, cd *CDict) error {
+	if err := isErr(loc, C.ZSTD_CCtx_refCDict(c.ctx, cd.cdict)); err != nil {
+		return err
+	}
+	c.clearDict()
+	c.cdict = cd
+	return nil
+}
+
+// clearDict forgets the dictionary, once zstd has
+// stopped referencing it.
+func (c *Compressor) clearDict() {
+	c.cdict = nil
+	c.dict = nil
+	releaseCBytes(&c.dictRef)
+}
+
+// cloneParams are the parameters copied by Clone,
+// in the order setOptions sets them.
+var cloneParams = []CParameter{
+	CParamCompressionLevel,
+	CParamChecksumFlag,
+	CParamContentSizeFlag,
+	CParamDictIDFlag,
+	CParamFormat,
+	CParamWindowLog,
+	CParamHashLog,
+	CParamChainLog,
+	CParamSearchLog,
+	CParamMinMatch,
+	CParamTargetLength,
+	CParamStrategy,
+	CParamLiteralCompressionMode,
+	CParamEnableLongDistanceMatching,
+	CParamLDMHashLog,
+	CParamLDMMinMatch,
+	CParamLDMBucketSizeLog,
+	CParamLDMHashRateLog,
+	CParamNBWorkers,
+	CParamJobSize,
+	CParamOverlapLog,
+	CParamRsyncable,
+}
+
+// Clone creates a new Compressor with the parameters and
+// dictionary of c. It is cheaper than building COptions again
+// and keeps the two configurations in sync.
+//
+// A CDict or DictionaryByReference bytes are shared with c.
+// A copied Dictionary is loaded again.
+//
+// Clone does not copy streaming state: a frame in progress,
+// a pledged source size, or a pending RefPrefix.
+func (c *Compressor) Clone() (*Compressor, error) {
+	c2, err := NewCompressor(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c2.cloneFrom(c); err != nil {
+		c2.Delete()
+		return nil, err
+	}
+	return c2, nil
+}
+
+func (c *Compressor) cloneFrom(src *Compressor) error {
+	c.skipBelow = src.skipBelow
+	c.allowStored = src.allowStored
+	for _, p := range cloneParams {
+		v, err := src.GetParameter(p)
+		if err != nil {
+			return xerrors.Errorf("zstdwrap.Clone: %w", err)
+		}
+		// Zero is the default for all but the content size
+		// flag, which NoContentSize clears.
+		if v == 0 && p != CParamContentSizeFlag {
+			continue
+		}
+		if err := c.setParameter("Clone", p, v); err != nil {
+			return err
+		}
+	}
+	switch {
+	case src.dictRef != nil:
+		return c.loadDictionaryRef("Clone", src.dictRef, src.dictCT)
+	case src.dict != nil:
+		return c.loadDictionary("Clone", src.dict, src.dictCT, DictLoadByCopy)
+	case src.cdict != nil:
+		return c.refCDict("Clone", src.cdict)
+	}
+	return nil
+}
+
 func (c *Compressor) Delete() error {
 	err := isErr("Delete", C.ZSTD_freeCCtx(c.ctx))
 	c.ctx = nil
+	c.clearDict()
+	c.scratch = nil
+	freePrefix(&c.prefix)
+	c.blocks.free()
 	return err
 }
 
+// finalize is the COptions.FreeOnFinalize finalizer.
+func (c *Compressor) finalize() {
+	if c.ctx != nil {
+		leaked("Compressor")
+	}
+	c.Delete()
+}
+
+// ResetDirective selects what Reset discards.
+type ResetDirective int
+
+const (
+	// ResetSessionOnly abandons the current frame.
+	// Parameters and dictionaries are kept.
+	ResetSessionOnly ResetDirective = C.ZSTD_reset_session_only
+
+	// ResetParameters restores all parameters to their
+	// defaults and drops any dictionary. It fails with
+	// ErrStageWrong in the middle of a frame.
+	ResetParameters ResetDirective = C.ZSTD_reset_parameters
+
+	// ResetSessionAndParameters does both.
+	ResetSessionAndParameters ResetDirective = C.ZSTD_reset_session_and_parameters
+)
+
+// Reset resets the Compressor with ZSTD_CCtx_reset,
+// reusing its allocated context.
+func (c *Compressor) Reset(directive ResetDirective) error {
+	res := C.ZSTD_CCtx_reset(c.ctx, C.ZSTD_ResetDirective(directive))
+	if err := isErr("Reset", res); err != nil {
+		return err
+	}
+	if directive != ResetSessionOnly {
+		c.clearDict()
+		c.skipBelow = 0
+		c.allowStored = false
+		c.checksum = false
+		c.noContentSize = false
+		c.format = FormatZstd1
+	}
+	return nil
+}
+
+// SetPledgedSrcSize declares the total size of the next frame's
+// content with ZSTD_CCtx_setPledgedSrcSize.
+//
+// It must be called before any data of the frame is written.
+// The size is recorded in the frame header, so FrameContentSize
+// can report it. If the content written does not match n, the
+// frame fails with ErrSrcSizeWrong.
+//
+// Compress does not need a pledged size: it always records
+// the exact size of src.
+func (c *Compressor) SetPledgedSrcSize(n int64) error {
+	res := C.ZSTD_CCtx_setPledgedSrcSize(c.ctx, C.ulonglong(n))
+	return isErr("SetPledgedSrcSize", res)
+}
+
+// FrameProgression reports how far compression of the
+// current frame has progressed. Sizes are in bytes.
+type FrameProgression struct {
+	Ingested        int64 // input read and buffered
+	Consumed        int64 // input compressed
+	Produced        int64 // compressed output generated
+	FlushedSize     int64 // compressed output flushed out of zstd's buffers
+	CurrentJobID    int   // latest started job, with NBWorkers
+	NBActiveWorkers int   // workers compressing, with NBWorkers
+}
+
+// Progression reports the progress of the frame being compressed
+// with ZSTD_getFrameProgression. It is useful between Writer
+// calls. With NBWorkers, Consumed lags Ingested while input is
+// waiting for a worker.
+func (c *Compressor) Progression() FrameProgression {
+	p := C.ZSTD_getFrameProgression(c.ctx)
+	return FrameProgression{
+		Ingested:        int64(p.ingested),
+		Consumed:        int64(p.consumed),
+		Produced:        int64(p.produced),
+		FlushedSize:     int64(p.flushed),
+		CurrentJobID:    int(p.currentJobID),
+		NBActiveWorkers: int(p.nbActiveWorkers),
+	}
+}
+
 // Compress compresses the contents of src into dst, and returns the new dst.
 //
 // If cap(dst) < CompressBound(len(src)), then memory will be allocated.
 //
+// The frame is written from the start of dst, replacing its
+// contents. To add a frame after existing contents, use
+// CompressAppend.
+//
+// The result shares memory with dst, when it is large enough,
+// and never with src, provided the two do not overlap. Reusing
+// dst while the result is in use is the caller's responsibility.
+// CompressCopy always returns a new slice.
+//
 // Always builds a complete frame.
 // Equivalent to ZSTD_compress2.
 func (c *Compressor) Compress(dst, src []byte) ([]byte, error) {
@@ -92,78 +664,324 @@ func (c *Compressor) Compress(dst, src []byte) ([]byte, error) {
 		dst = dst[:need]
 	}
 
-	dstv := unsafe.Pointer(&dst[0])
-	srcv := unsafe.Pointer(&src[0])
-	res := C.ZSTD_compress2(c.ctx, dstv, C.size_t(len(dst)), srcv, C.size_t(len(src)))
-	if err := isErr("Compress", res); err != nil {
+	n, err := c.compress("Compress", dst, src)
+	if err != nil {
 		return nil, err
 	}
-	dst = dst[:int(res)]
-	return dst, nil
+	return dst[:n], nil
+}
+
+// CompressAppend compresses src into a complete frame appended
+// to dst, and returns the extended slice. The existing contents
+// of dst are kept, so a buffer can accumulate many frames.
+//
+// If cap(dst)-len(dst) < CompressBound(len(src)), then memory
+// will be allocated.
+func (c *Compressor) CompressAppend(dst, src []byte) ([]byte, error) {
+	start := len(dst)
+	if need := start + CompressBound(len(src)); cap(dst) < need {
+		dst = append(dst, make([]byte, need-len(dst))...)
+	} else {
+		dst = dst[:need]
+	}
+
+	n, err := c.compress("CompressAppend", dst[start:], src)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:start+n], nil
 }
 
+// CompressMulti compresses each of srcs into its own frame.
+// The frames are concatenated into dst, and the new dst and
+// the offset of each frame in it are returned.
+//
+// The frames are independent, so they can be decompressed
+// separately or in parallel.
+func (c *Compressor) CompressMulti(dst []byte, srcs [][]byte) ([]byte, []int, error) {
+	need := 0
+	for _, src := range srcs {
+		need += CompressBound(len(src))
+	}
+	if cap(dst) < need {
+		dst = append(dst, make([]byte, need-len(dst))...)
+	} else {
+		dst = dst[:need]
+	}
+	offsets := make([]int, len(srcs))
+	off := 0
+	for i, src := range srcs {
+		offsets[i] = off
+		n, err := c.compress("CompressMulti", dst[off:], src)
+		if err != nil {
+			return nil, nil, err
+		}
+		off += n
+	}
+	return dst[:off], offsets, nil
+}
+
+// CompressInto compresses the contents of src into dst[:cap(dst)],
+// and returns the number of bytes written.
+//
+// CompressInto never allocates. If dst is too small for the frame,
+// it reports ErrDstSizeTooSmall. A cap(dst) of at least
+// CompressBound(len(src)) is always large enough.
+func (c *Compressor) CompressInto(dst, src []byte) (n int, err error) {
+	return c.compress("CompressInto", dst[:cap(dst)], src)
+}
+
+// CompressedSize reports the size of the frame Compress would
+// produce for src, without returning it.
+//
+// The frame is compressed into a scratch buffer held by the
+// Compressor and reused by later calls, so it only allocates
+// when src is larger than before. Delete releases the buffer.
+func (c *Compressor) CompressedSize(src []byte) (int, error) {
+	if need := CompressBound(len(src)); cap(c.scratch) < need {
+		c.scratch = make([]byte, need)
+	}
+	return c.compress("CompressedSize", c.scratch[:cap(c.scratch)], src)
+}
+
+func (c *Compressor) compress(loc string, dst, src []byte) (n int, err error) {
+	c.guard.enter("Compressor")
+	defer c.guard.exit()
+
+	if len(src) < c.skipBelow && !c.checksum && c.prefix == nil {
+		return storedFrame(loc, c.format, !c.noContentSize, dst, src)
+	}
+	var dstv, srcv unsafe.Pointer
+	if len(dst) > 0 {
+		dstv = unsafe.Pointer(&dst[0])
+	}
+	if len(src) > 0 {
+		srcv = unsafe.Pointer(&src[0])
+	}
+	res := C.ZSTD_compress2(c.ctx, dstv, C.size_t(len(dst)), srcv, C.size_t(len(src)))
+	if err := isErr(loc, res); err != nil {
+		return 0, err
+	}
+	if c.allowStored && !c.checksum && int(res) > storedSize(c.format, !c.noContentSize, len(src)) {
+		return storedFrame(loc, c.format, !c.noContentSize, dst, src)
+	}
+	return int(res), nil
+}
+
+// CompressBound reports the maximum compressed size of srcSize bytes.
+//
+// It is the ZSTD_COMPRESSBOUND macro implemented in Go,
+// saving a cgo call.
 func CompressBound(srcSize int) int {
-	// TODO: this is a one-line macro. Implement directly in Go.
+	const smallLimit = 128 << 10
+	margin := 0
+	if srcSize < smallLimit {
+		margin = (smallLimit - srcSize) >> 11
+	}
+	return srcSize + (srcSize >> 8) + margin
+}
+
+// compressBoundC is ZSTD_compressBound, for testing CompressBound.
+func compressBoundC(srcSize int) int {
 	return int(C.ZSTD_compressBound(C.size_t(srcSize)))
 }
 
+// DOptions configures a Decompressor.
 type DOptions struct {
-	WindowLogMax int // 0 default, otherwise must be power of 2
+	// WindowLogMax is the log2 of the largest window size,
+	// in bytes, the Decompressor will accept.
+	// See NewDecompressor.
+	WindowLogMax int
+
+	// MaxWindowSizeBytes is the largest window size the
+	// Decompressor will accept, rounded up to a power of two.
+	// It must be at least 1kb (1<<ZSTD_WINDOWLOG_MIN).
+	// WindowLogMax and MaxWindowSizeBytes cannot both be set.
+	MaxWindowSizeBytes int64
+
+	// Format selects the frame format. It must match the
+	// COptions.Format the frames were compressed with.
+	// See SetFormat.
+	Format Format
+
+	// IgnoreChecksum skips checking frame checksums.
+	// See SetIgnoreChecksum.
+	IgnoreChecksum bool
+
+	// Dictionary is loaded into the Decompressor, as by
+	// LoadDictionary, and used for every frame it decodes.
+	// The bytes are copied.
+	Dictionary []byte
+
+	// FreeOnFinalize sets a finalizer that frees the zstd context
+	// if the Decompressor is garbage collected without Delete.
+	// See COptions.FreeOnFinalize.
+	FreeOnFinalize bool
 }
 
 type Decompressor struct {
-	ctx          *C.ZSTD_DCtx
-	windowLogMax int
+	ctx            *C.ZSTD_DCtx
+	windowLogMax   int
+	format         Format
+	ddict          *DDict
+	dictRef        *cBytes        // referenced by zstd, see DictLoadByRef
+	prefix         unsafe.Pointer // C copy of the RefPrefix bytes
+	ignoreChecksum bool
+	forceDict      bool
+	blocks         blockHistory
+	guard          useGuard
+
+	// Stream positions for decompressStreamInto, held here
+	// so passing them to C does not allocate.
+	dstPos, srcPos C.size_t
+
+	sizeCache sizeCache
+}
+
+// sizeCache holds the result of the last frame header parsed
+// by frameContentSize, so decoding the same frame in a loop
+// does not call into zstd to parse it again.
+//
+// The content size depends only on the frame header, so the
+// cache is keyed on the header bytes rather than the address
+// of src. A buffer reused for a different frame is a miss.
+type sizeCache struct {
+	hdr    [C.ZSTD_FRAMEHEADERSIZE_MAX]byte
+	hdrLen int
+	format Format
+	size   int64
+	err    error
+	misses int // headers parsed, for tests
 }
 
 // NewDecompressor creates a Decompressor.
 //
-// The maximum frame that can be decompressed is windowLogMax,
-// which must be a power of two.
-// If zero, the default is 1<<ZSTD_WINDOWLOG_LIMIT_DEFAULT (128mb).
+// The windowLogMax is the log2 of the largest window size,
+// in bytes, the Decompressor will accept. Decompress also
+// refuses frames with content larger than 1<<windowLogMax.
+// If zero, the default is ZSTD_WINDOWLOG_LIMIT_DEFAULT (27, 128mb).
 func NewDecompressor(windowLogMax int) (*Decompressor, error) {
+	return NewDecompressorOpts(&DOptions{WindowLogMax: windowLogMax})
+}
+
+// NewDecompressorOpts creates a Decompressor configured with opts.
+// A nil opts is equivalent to NewDecompressor(0).
+func NewDecompressorOpts(opts *DOptions) (*Decompressor, error) {
 	d := &Decompressor{
-		ctx:          C.ZSTD_createDCtx(),
-		windowLogMax: windowLogMax,
+		ctx: C.ZSTD_createDCtx(),
 	}
 	if d.ctx == nil {
 		return nil, fmt.Errorf("zstdwrap: ZSTD_createDCtx failed")
 	}
-	if d.windowLogMax == 0 {
-		d.windowLogMax = int(1 << C.ZSTD_WINDOWLOG_LIMIT_DEFAULT)
-	} else {
-		res := C.ZSTD_DCtx_setParameter(d.ctx, C.ZSTD_d_windowLogMax, C.int(d.windowLogMax))
-		if err := isErr("NewDecompressor(windowlog)", res); err != nil {
-			return nil, err
-		}
+	if opts == nil {
+		opts = &DOptions{}
+	}
+	if err := d.setOptions(opts); err != nil {
+		d.Delete()
+		return nil, err
+	}
+	if opts.FreeOnFinalize {
+		runtime.SetFinalizer(d, (*Decompressor).finalize)
 	}
 	return d, nil
 }
 
+func (d *Decompressor) setOptions(opts *DOptions) error {
+	windowLogMax := opts.WindowLogMax
+	if n := opts.MaxWindowSizeBytes; n != 0 {
+		if windowLogMax != 0 {
+			return errors.New("zstdwrap.NewDecompressor: WindowLogMax and MaxWindowSizeBytes are exclusive")
+		}
+		if n < 1<<C.ZSTD_WINDOWLOG_MIN || n > 1<<C.ZSTD_WINDOWLOG_MAX {
+			return xerrors.Errorf("zstdwrap.NewDecompressor: MaxWindowSizeBytes %d: %w", n, ErrParameterOutOfBound)
+		}
+		windowLogMax = bits.Len64(uint64(n - 1)) // round up
+	}
+	if err := d.setParameter("NewDecompressor(windowlog)", DParamWindowLogMax, windowLogMax); err != nil {
+		return err
+	}
+	if opts.Format != FormatZstd1 {
+		if err := d.setParameter("NewDecompressor(format)", DParamFormat, int(opts.Format)); err != nil {
+			return err
+		}
+	}
+	d.ignoreChecksum = opts.IgnoreChecksum
+	if len(opts.Dictionary) > 0 {
+		return d.loadDictionary("NewDecompressor(dictionary)", opts.Dictionary, DictContentAuto, DictLoadByCopy)
+	}
+	return nil
+}
+
 // Decompress decompresse the contents of src into dst, and returns the new dst.
 //
 // Decompress requires the frame being decompressed be smaller
 // than cap(dst) or be smaller than the Decompressor's maximum
-// window log.
+// window log. Larger frames report ErrFrameTooBig.
+//
+// When src holds several frames, the window limit applies to
+// each frame, and dst is allocated once for the sum of their
+// content sizes.
+//
+// If the frame header does not record the content size,
+// the frame is decoded with ZSTD_decompressStream, growing
+// dst as needed up to the maximum window size.
+//
+// When cap(dst) is at least the content size, the content is
+// written to dst's backing array and Decompress does not
+// allocate. Otherwise the result is a new slice. Callers that
+// need the content in a fixed region, such as a memory-mapped
+// file, should use DecompressInto, which never moves it.
 //
 // The len(src) must be exactly equal to the byte length of one
-// or more frames.
+// or more frames. If src ends part way through a frame,
+// Decompress reports ErrTruncatedFrame.
 func (d *Decompressor) Decompress(dst, src []byte) ([]byte, error) {
-	if src == nil {
-		return nil, errors.New("zstdwrap.Decompress: nil src")
+	d.guard.enter("Decompressor")
+	defer d.guard.exit()
+
+	out, err := d.decompress(dst, src)
+	if err != nil {
+		return nil, truncated("Decompress", src, err)
 	}
-	if dst != nil {
-		dst = dst[:cap(dst)]
+	return out, nil
+}
+
+func (d *Decompressor) decompress(dst, src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, errors.New("zstdwrap.Decompress: empty src")
 	}
-	if contentSize, err := FrameContentSize(src); err != nil {
+	if d.ignoreChecksum || d.forceDict {
+		var err error
+		if src, err = d.rewriteFrames("Decompress", src); err != nil {
+			return nil, err
+		}
+	}
+	contentSize, largest, err := d.contentSizes(src)
+	if err == ErrContentSizeUnknown {
+		return d.decompressStream(dst[:cap(dst)], src)
+	} else if err != nil {
 		return nil, xerrors.Errorf("zstdwrap.Decompress: %w", err)
-	} else if contentSize > int64(d.windowLogMax) {
-		return nil, xerrors.Errorf("zstdwrap.Decompress: frame too big: %d", contentSize)
-	} else if int(contentSize) > len(dst) {
-		dst = append(dst, make([]byte, int(contentSize)-len(dst))...)
+	} else if largest > d.maxContentSize() {
+		return nil, xerrors.Errorf("zstdwrap.Decompress: %w", &FrameTooBigError{Size: largest, Limit: d.maxContentSize()})
+	}
+	// The content is written from dst[0], so a dst that is too
+	// small is replaced, not grown, to avoid copying its contents.
+	if int64(cap(dst)) < contentSize {
+		dst = make([]byte, contentSize)
+	} else {
+		dst = dst[:cap(dst)]
+	}
+	if d.format != FormatZstd1 {
+		// ZSTD_decompressDCtx expects a magic number.
+		return d.decompressStream(dst, src)
 	}
 
-	dstv := unsafe.Pointer(&dst[0])
+	var dstv unsafe.Pointer
+	if len(dst) > 0 {
+		dstv = unsafe.Pointer(&dst[0])
+	} else if dst == nil {
+		dst = []byte{} // empty frame content
+	}
 	srcv := unsafe.Pointer(&src[0])
 	res := C.ZSTD_decompressDCtx(d.ctx, dstv, C.size_t(len(dst)), srcv, C.size_t(len(src)))
 	if err := isErr("Decompress", res); err != nil {
@@ -173,19 +991,403 @@ func (d *Decompressor) Decompress(dst, src []byte) ([]byte, error) {
 	return dst, nil
 }
 
+// DecompressInto decompresses the contents of src into
+// dst[:cap(dst)], and returns the number of bytes written.
+//
+// DecompressInto never allocates or grows dst, so dst can be
+// a fixed region such as a memory-mapped file. If the frame
+// header records a content size larger than cap(dst), or the
+// content does not fit, it reports ErrDstSizeTooSmall. If src
+// ends part way through a frame, it reports ErrTruncatedFrame.
+func (d *Decompressor) DecompressInto(dst, src []byte) (n int, err error) {
+	d.guard.enter("Decompressor")
+	defer d.guard.exit()
+
+	n, err = d.decompressInto(dst, src)
+	if err != nil {
+		return 0, truncated("DecompressInto", src, err)
+	}
+	return n, nil
+}
+
+func (d *Decompressor) decompressInto(dst, src []byte) (n int, err error) {
+	if len(src) == 0 {
+		return 0, errors.New("zstdwrap.DecompressInto: empty src")
+	}
+	if d.ignoreChecksum || d.forceDict {
+		if src, err = d.rewriteFrames("DecompressInto", src); err != nil {
+			return 0, err
+		}
+	}
+	dst = dst[:cap(dst)]
+	contentSize, _, err := d.contentSizes(src)
+	if err == ErrContentSizeUnknown || (err == nil && d.format != FormatZstd1) {
+		return d.decompressStreamInto(dst, src)
+	} else if err != nil {
+		return 0, xerrors.Errorf("zstdwrap.DecompressInto: %w", err)
+	} else if contentSize > int64(len(dst)) {
+		return 0, xerrors.Errorf("zstdwrap.DecompressInto: content size %d: %w", contentSize, ErrDstSizeTooSmall)
+	}
+
+	var dstv unsafe.Pointer
+	if len(dst) > 0 {
+		dstv = unsafe.Pointer(&dst[0])
+	}
+	srcv := unsafe.Pointer(&src[0])
+	res := C.ZSTD_decompressDCtx(d.ctx, dstv, C.size_t(len(dst)), srcv, C.size_t(len(src)))
+	if err := isErr("DecompressInto", res); err != nil {
+		return 0, err
+	}
+	return int(res), nil
+}
+
+// DecompressFrameAt decompresses the single frame that starts
+// at src[offset:] into dst, as Decompress does, and returns the
+// offset of the following frame. The frame's end is found with
+// FrameCompressedSize.
+//
+// Starting at offset 0 and passing each returned next as the
+// following offset visits every frame in src. When next is
+// len(src), there are no more frames.
+func (d *Decompressor) DecompressFrameAt(dst, src []byte, offset int) (out []byte, next int, err error) {
+	if offset < 0 || offset >= len(src) {
+		return nil, offset, xerrors.Errorf("zstdwrap.DecompressFrameAt: offset %d outside src of %d bytes: %w", offset, len(src), ErrSrcSizeWrong)
+	}
+	if d.format != FormatZstd1 {
+		return nil, offset, xerrors.Errorf("zstdwrap.DecompressFrameAt: magicless frames: %w", ErrParameterUnsupported)
+	}
+	n, err := FrameCompressedSize(src[offset:])
+	if err == ErrSrcSizeWrong {
+		err = ErrTruncatedFrame
+	}
+	if err != nil {
+		return nil, offset, xerrors.Errorf("zstdwrap.DecompressFrameAt: frame at %d: %w", offset, err)
+	}
+	next = offset + n
+	out, err = d.Decompress(dst, src[offset:next])
+	if err != nil {
+		return nil, offset, err
+	}
+	return out, next, nil
+}
+
+// DecompressEach decompresses each frame in src in turn and calls
+// fn with its content. Skippable frames are passed over.
+//
+// The content is decoded into one buffer that is reused for every
+// frame, growing to the largest, so fn must not retain frame after
+// it returns. If fn returns an error, DecompressEach stops and
+// returns it.
+func (d *Decompressor) DecompressEach(src []byte, fn func(frame []byte) error) error {
+	var buf []byte
+	for offset := 0; offset < len(src); {
+		if IsSkippableFrame(src[offset:]) {
+			n, err := FrameCompressedSize(src[offset:])
+			if err == ErrSrcSizeWrong {
+				err = ErrTruncatedFrame
+			}
+			if err != nil {
+				return xerrors.Errorf("zstdwrap.DecompressEach: frame at %d: %w", offset, err)
+			}
+			offset += n
+			continue
+		}
+		out, next, err := d.DecompressFrameAt(buf[:0], src, offset)
+		if err != nil {
+			return err
+		}
+		if err := fn(out); err != nil {
+			return err
+		}
+		buf, offset = out, next
+	}
+	return nil
+}
+
+// SetFormat sets the format of the frames to decompress.
+// The default is FormatZstd1.
+func (d *Decompressor) SetFormat(f Format) error {
+	return d.setParameter("SetFormat", DParamFormat, int(f))
+}
+
+// SetIgnoreChecksum makes Decompress and DecompressInto skip
+// checking frame checksums. Corrupted content that the checksum
+// would have caught is returned as though it were valid,
+// so this is only for recovering data from encoders known
+// to write bad checksums.
+//
+// The bundled zstd predates ZSTD_d_forceIgnoreChecksum.
+// Instead each frame is copied without its checksum before
+// decoding, so only FormatZstd1 frames are supported and
+// a Reader still verifies checksums.
+func (d *Decompressor) SetIgnoreChecksum(ignore bool) {
+	d.ignoreChecksum = ignore
+}
+
+// SetForceDictionary makes Decompress and DecompressInto use
+// the loaded dictionary for every frame, even one that records
+// the ID of a different dictionary. Without it, such frames
+// fail with ErrDictionaryWrong.
+//
+// This is for legacy frames whose recorded dictionary ID is
+// wrong. Decoding a frame with a dictionary other than the one
+// it was compressed with returns garbage or reports corruption,
+// and only a frame checksum reliably detects it.
+//
+// As with SetIgnoreChecksum, each frame is copied without its
+// dictionary ID before decoding, so only FormatZstd1 frames
+// are supported and a Reader still checks the ID.
+func (d *Decompressor) SetForceDictionary(force bool) {
+	d.forceDict = force
+}
+
+// rewriteFrames returns a copy of the frames in src without
+// the content checksum of each, if ignoreChecksum is set,
+// and without the dictionary ID, if forceDict is set.
+func (d *Decompressor) rewriteFrames(loc string, src []byte) ([]byte, error) {
+	if d.format != FormatZstd1 {
+		return nil, xerrors.Errorf("zstdwrap.%s: rewriting magicless frames: %w", loc, ErrParameterUnsupported)
+	}
+	// Frame_Header_Descriptor fields.
+	const (
+		dictIDFlag    = 3
+		checksumFlag  = 1 << 2
+		singleSegment = 1 << 5
+	)
+	out := make([]byte, 0, len(src))
+	for len(src) > 0 {
+		n, err := FrameCompressedSize(src)
+		if err != nil {
+			return nil, xerrors.Errorf("zstdwrap.%s: %w", loc, err)
+		}
+		frame := src[:n]
+		src = src[n:]
+		if IsSkippableFrame(frame) {
+			out = append(out, frame...)
+			continue
+		}
+		fhd := frame[4]
+		if d.ignoreChecksum && fhd&checksumFlag != 0 {
+			frame = frame[:len(frame)-4]
+			fhd &^= checksumFlag
+		}
+		start := len(out)
+		if d.forceDict && fhd&dictIDFlag != 0 {
+			idPos := 5
+			if fhd&singleSegment == 0 {
+				idPos++ // Window_Descriptor
+			}
+			idSize := [4]int{0, 1, 2, 4}[fhd&dictIDFlag]
+			out = append(out, frame[:idPos]...)
+			out = append(out, frame[idPos+idSize:]...)
+			fhd &^= dictIDFlag
+		} else {
+			out = append(out, frame...)
+		}
+		out[start+4] = fhd
+	}
+	return out, nil
+}
+
+// contentSizes reports the total content size of the frames
+// in src, and the content size of the largest frame.
+//
+// Magicless frames cannot be split without decoding them,
+// so only the first is counted.
+func (d *Decompressor) contentSizes(src []byte) (total, largest int64, err error) {
+	for len(src) > 0 {
+		sz, err := d.frameContentSize(src)
+		if err != nil {
+			return 0, 0, err
+		}
+		if sz > math.MaxInt64-total {
+			return 0, 0, xerrors.Errorf("content size overflows: %w", ErrBadFrame)
+		}
+		total += sz
+		if sz > largest {
+			largest = sz
+		}
+		if d.format != FormatZstd1 {
+			break
+		}
+		n, err := FrameCompressedSize(src)
+		if err != nil {
+			return 0, 0, err
+		}
+		src = src[n:]
+	}
+	return total, largest, nil
+}
+
+// frameContentSize is FrameContentSize in the Decompressor's format.
+func (d *Decompressor) frameContentSize(src []byte) (int64, error) {
+	sc := &d.sizeCache
+	hdr := src
+	if len(hdr) > len(sc.hdr) {
+		hdr = hdr[:len(sc.hdr)]
+	}
+	if len(hdr) > 0 && sc.hdrLen == len(hdr) && sc.format == d.format && string(sc.hdr[:sc.hdrLen]) == string(hdr) {
+		return sc.size, sc.err
+	}
+	sc.size, sc.err = d.parseContentSize(src)
+	sc.hdrLen = copy(sc.hdr[:], hdr)
+	sc.format = d.format
+	sc.misses++
+	return sc.size, sc.err
+}
+
+func (d *Decompressor) parseContentSize(src []byte) (int64, error) {
+	if d.format == FormatZstd1 {
+		return FrameContentSize(src)
+	}
+	var zfh C.ZSTD_frameHeader
+	res := C.ZSTD_getFrameHeader_advanced(&zfh, unsafe.Pointer(&src[0]), C.size_t(len(src)), C.ZSTD_format_e(d.format))
+	if res != 0 {
+		// Either an error or a truncated header.
+		return 0, ErrBadFrame
+	}
+	if zfh.frameContentSize == C.ZSTD_CONTENTSIZE_UNKNOWN {
+		return 0, ErrContentSizeUnknown
+	}
+	return int64(zfh.frameContentSize), nil
+}
+
+// maxContentSize is the largest frame content Decompress accepts.
+func (d *Decompressor) maxContentSize() int64 {
+	return int64(1) << uint(d.windowLogMax)
+}
+
+// LoadDictionary loads dict into the Decompressor with
+// ZSTD_DCtx_loadDictionary. The dictionary is used for all
+// subsequent calls to Decompress. The bytes are copied.
+//
+// A nil or empty dict returns the Decompressor to
+// no-dictionary mode.
+//
+// A frame that records the ID of a different dictionary
+// fails to decompress with ErrDictionaryWrong.
+func (d *Decompressor) LoadDictionary(dict []byte) error {
+	return d.loadDictionary("LoadDictionary", dict, DictContentAuto, DictLoadByCopy)
+}
+
+func (d *Decompressor) loadDictionary(loc string, dict []byte, ct DictContentType, lm DictLoadMethod) error {
+	var dictv unsafe.Pointer
+	var ref *cBytes
+	switch {
+	case len(dict) == 0:
+	case lm == DictLoadByRef:
+		ref = newCBytes(dict)
+		dictv = ref.p
+	default:
+		dictv = unsafe.Pointer(&dict[0])
+	}
+	res := C.ZSTD_DCtx_loadDictionary_advanced(d.ctx, dictv, C.size_t(len(dict)),
+		C.ZSTD_dictLoadMethod_e(lm), C.ZSTD_dictContentType_e(ct))
+	d.ddict = nil
+	releaseCBytes(&d.dictRef)
+	if err := isErr(loc, res); err != nil {
+		releaseCBytes(&ref)
+		if derr := checkDictType(loc, dict, ct); derr != nil {
+			return derr
+		}
+		return err
+	}
+	d.dictRef = ref
+	return nil
+}
+
+// RefDDict references a digested dictionary with ZSTD_DCtx_refDDict.
+// It replaces any dictionary loaded by LoadDictionary.
+//
+// A nil dd returns the Decompressor to no-dictionary mode.
+func (d *Decompressor) RefDDict(dd *DDict) error {
+	var ddict *C.ZSTD_DDict
+	if dd != nil {
+		ddict = dd.ddict
+	}
+	res := C.ZSTD_DCtx_refDDict(d.ctx, ddict)
+	d.ddict = dd
+	releaseCBytes(&d.dictRef)
+	return isErr("RefDDict", res)
+}
+
+// Reset resets the Decompressor with ZSTD_DCtx_reset,
+// reusing its allocated context.
+//
+// A session reset recovers the Decompressor after
+// a frame fails to decompress.
+func (d *Decompressor) Reset(directive ResetDirective) error {
+	res := C.ZSTD_DCtx_reset(d.ctx, C.ZSTD_ResetDirective(directive))
+	if err := isErr("Reset", res); err != nil {
+		return err
+	}
+	if directive != ResetSessionOnly {
+		d.windowLogMax = C.ZSTD_WINDOWLOG_LIMIT_DEFAULT
+		d.format = FormatZstd1
+		d.ddict = nil
+		releaseCBytes(&d.dictRef)
+		d.ignoreChecksum = false
+		d.forceDict = false
+	}
+	return nil
+}
+
 func (d *Decompressor) Delete() error {
 	err := isErr("Delete", C.ZSTD_freeDCtx(d.ctx))
 	d.ctx = nil
+	releaseCBytes(&d.dictRef)
+	freePrefix(&d.prefix)
+	d.blocks.free()
 	return err
 }
 
+// finalize is the DOptions.FreeOnFinalize finalizer.
+func (d *Decompressor) finalize() {
+	if d.ctx != nil {
+		leaked("Decompressor")
+	}
+	d.Delete()
+}
+
+// leaks counts contexts freed by a finalizer instead of Delete.
+var leaks int64
+
+func leaked(typ string) {
+	atomic.AddInt64(&leaks, 1)
+	logLeak(typ)
+}
+
 var ErrContentSizeUnknown = errors.New("zstdwrap: unknown frame content size")
 var ErrBadFrame = errors.New("zstdwrap: bad frame")
 
+// ErrTruncatedFrame reports a src that ends part way through
+// a frame. Unlike ErrCorruptionDetected, decoding may succeed
+// when more of the frame is available.
+//
+// A frame whose block headers are corrupted so that they
+// claim more data than src holds also looks truncated.
+//
+// ErrTruncatedFrame wraps ErrSrcSizeWrong.
+var ErrTruncatedFrame error = truncatedFrameError{}
+
+type truncatedFrameError struct{}
+
+func (truncatedFrameError) Error() string { return "zstdwrap: truncated frame" }
+func (truncatedFrameError) Unwrap() error { return ErrSrcSizeWrong }
+
+// truncated reports ErrTruncatedFrame in place of err
+// if src ends part way through a frame.
+func truncated(loc string, src []byte, err error) error {
+	if _, rest, ferr := FrameSizes(src); ferr == nil && rest > 0 {
+		return xerrors.Errorf("zstdwrap.%s: %w", loc, ErrTruncatedFrame)
+	}
+	return err
+}
+
 // FrameContentSize reports the decompressed size of a frame's content.
 func FrameContentSize(src []byte) (int64, error) {
 	var srcv unsafe.Pointer
-	if src != nil {
+	if len(src) > 0 {
 		srcv = unsafe.Pointer(&src[0])
 	}
 	sz := C.ZSTD_getFrameContentSize(srcv, C.size_t(len(src)))
@@ -197,6 +1399,149 @@ func FrameContentSize(src []byte) (int64, error) {
 	return int64(sz), nil
 }
 
+// FrameHeader describes a zstd frame, from RFC 8478 section 3.1.1.1.
+type FrameHeader struct {
+	ContentSize    int64  // valid if HasContentSize
+	HasContentSize bool   // frame header records the content size
+	WindowSize     int64  // bytes of history needed to decompress
+	DictID         uint32 // 0 if no dictionary ID is recorded
+	Checksum       bool   // frame ends with a content checksum
+	HeaderSize     int    // size of the frame header in bytes
+}
+
+// ErrFrameTooBig is reported by Decompress when a frame's content
+// is larger than the Decompressor's limit. The error is a
+// *FrameTooBigError, which holds the sizes.
+var ErrFrameTooBig = errors.New("zstdwrap: frame too big")
+
+// FrameTooBigError reports a frame whose content is larger
+// than the Decompressor's limit. It matches ErrFrameTooBig.
+type FrameTooBigError struct {
+	Size  int64 // content size, 0 if not recorded in the frame
+	Limit int64 // largest content accepted, 1<<windowLogMax
+}
+
+func (e *FrameTooBigError) Error() string {
+	if e.Size == 0 {
+		return fmt.Sprintf("frame too big: more than %d", e.Limit)
+	}
+	return fmt.Sprintf("frame too big: %d, more than %d", e.Size, e.Limit)
+}
+
+// Is reports whether target is ErrFrameTooBig.
+func (e *FrameTooBigError) Is(target error) bool {
+	return target == ErrFrameTooBig
+}
+
+// NeedMoreError reports that src is too short to hold a frame header.
+type NeedMoreError struct {
+	Need int // total bytes required
+}
+
+func (e *NeedMoreError) Error() string {
+	return fmt.Sprintf("zstdwrap: frame header needs %d bytes", e.Need)
+}
+
+// ReadFrameHeader parses the header of the frame at the start of src.
+//
+// If src is too short to hold the header, ReadFrameHeader returns
+// a *NeedMoreError. At most ZSTD_FRAMEHEADERSIZE_MAX (18) bytes
+// are needed.
+// Equivalent to ZSTD_getFrameHeader.
+func ReadFrameHeader(src []byte) (FrameHeader, error) {
+	var srcv unsafe.Pointer
+	if len(src) > 0 {
+		srcv = unsafe.Pointer(&src[0])
+	}
+	var zfh C.ZSTD_frameHeader
+	res := C.ZSTD_getFrameHeader(&zfh, srcv, C.size_t(len(src)))
+	if err := isErr("ReadFrameHeader", res); err != nil {
+		return FrameHeader{}, err
+	} else if res != 0 {
+		return FrameHeader{}, &NeedMoreError{Need: int(res)}
+	}
+	if zfh.frameType != C.ZSTD_frame {
+		return FrameHeader{}, ErrBadFrame
+	}
+	hdr := FrameHeader{
+		WindowSize: int64(zfh.windowSize),
+		DictID:     uint32(zfh.dictID),
+		Checksum:   zfh.checksumFlag != 0,
+		HeaderSize: int(zfh.headerSize),
+	}
+	if zfh.frameContentSize != C.ZSTD_CONTENTSIZE_UNKNOWN {
+		hdr.ContentSize = int64(zfh.frameContentSize)
+		hdr.HasContentSize = true
+	}
+	return hdr, nil
+}
+
+// ReadFrameHeaderFrom reads and parses the header of the frame
+// at the start of r, without a Decompressor.
+//
+// It reads only the bytes of the header, leaving r at the first
+// block, and returns them so they can be put back in front of r
+// before decompressing, for example with io.MultiReader.
+//
+// If r ends before the header is complete, ReadFrameHeaderFrom
+// returns the bytes read and a *NeedMoreError with the total
+// needed. Other read errors are returned as is.
+func ReadFrameHeaderFrom(r io.Reader) (FrameHeader, []byte, error) {
+	buf := make([]byte, 0, C.ZSTD_FRAMEHEADERSIZE_MAX)
+	need := C.ZSTD_FRAMEHEADERSIZE_PREFIX
+	for {
+		n, err := io.ReadFull(r, buf[len(buf):need])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return FrameHeader{}, buf, &NeedMoreError{Need: need}
+		} else if err != nil {
+			return FrameHeader{}, buf, err
+		}
+		hdr, err := ReadFrameHeader(buf)
+		if e, ok := err.(*NeedMoreError); ok && e.Need > len(buf) {
+			need = e.Need
+			continue
+		}
+		return hdr, buf, err
+	}
+}
+
+// GetDictIDFromFrame reports the ID of the dictionary needed
+// to decompress the frame at the start of src.
+//
+// It reports 0 if the frame does not need a dictionary or
+// does not record its ID.
+// Equivalent to ZSTD_getDictID_fromFrame.
+func GetDictIDFromFrame(src []byte) (uint32, error) {
+	if len(src) == 0 {
+		return 0, ErrBadFrame
+	}
+	var zfh C.ZSTD_frameHeader
+	res := C.ZSTD_getFrameHeader(&zfh, unsafe.Pointer(&src[0]), C.size_t(len(src)))
+	if res != 0 {
+		return 0, ErrBadFrame
+	}
+	return uint32(C.ZSTD_getDictID_fromFrame(unsafe.Pointer(&src[0]), C.size_t(len(src)))), nil
+}
+
+// DecompressBound reports an upper bound on the decompressed size
+// of src, which must be exactly one or more complete frames.
+//
+// Unlike FrameContentSize, it sums across concatenated frames and
+// works on frames that do not record their content size.
+// Equivalent to ZSTD_decompressBound.
+func DecompressBound(src []byte) (int64, error) {
+	var srcv unsafe.Pointer
+	if len(src) > 0 {
+		srcv = unsafe.Pointer(&src[0])
+	}
+	sz := C.ZSTD_decompressBound(srcv, C.size_t(len(src)))
+	if sz == C.ZSTD_CONTENTSIZE_ERROR {
+		return 0, ErrBadFrame
+	}
+	return int64(sz), nil
+}
+
 // FrameCompressedSize reports the size of a frame.
 // For the reported value n, buf[:n] is a valid src for Decompress.
 func FrameCompressedSize(buf []byte) (n int, err error) {
@@ -215,6 +1560,61 @@ func FrameCompressedSize(buf []byte) (n int, err error) {
 	return int(res), nil
 }
 
+// FrameSizes splits buf, a sequence of concatenated frames,
+// reporting the compressed size of each frame in order.
+//
+// If buf ends with an incomplete frame, its length is
+// reported as rest and err is nil.
+func FrameSizes(buf []byte) (sizes []int, rest int, err error) {
+	for len(buf) > 0 {
+		n, err := FrameCompressedSize(buf)
+		if err == ErrSrcSizeWrong {
+			break // truncated frame
+		} else if err != nil {
+			return sizes, len(buf), xerrors.Errorf("zstdwrap.FrameSizes: %w", err)
+		}
+		sizes = append(sizes, n)
+		buf = buf[n:]
+	}
+	return sizes, len(buf), nil
+}
+
+// TotalContentSize reports the sum of the content sizes recorded
+// in the headers of the frames in src. Skippable frames count
+// as empty.
+//
+// Unlike DecompressBound, it does not estimate: if any frame does
+// not record its content size, it reports ErrContentSizeUnknown.
+// The result can be checked before allocating to reject
+// decompression bombs.
+func TotalContentSize(src []byte) (int64, error) {
+	var total int64
+	for len(src) > 0 {
+		n, err := FrameCompressedSize(src)
+		if err != nil {
+			return 0, xerrors.Errorf("zstdwrap.TotalContentSize: %w", err)
+		}
+		sz, err := FrameContentSize(src[:n])
+		if err != nil {
+			return 0, err
+		}
+		if sz < 0 || sz > math.MaxInt64-total {
+			return 0, xerrors.Errorf("zstdwrap.TotalContentSize: content size overflows: %w", ErrBadFrame)
+		}
+		total += sz
+		src = src[n:]
+	}
+	return total, nil
+}
+
+// IsCompleteFrame reports whether src consists of one or more
+// complete frames, with no trailing bytes, as Decompress requires.
+// Truncated or invalid input reports false.
+func IsCompleteFrame(src []byte) bool {
+	sizes, rest, err := FrameSizes(src)
+	return err == nil && rest == 0 && len(sizes) > 0
+}
+
 func isErr(loc string, res C.size_t) error {
 	code := int(C.ZSTD_getErrorCode(res))
 	if code == 0 {
@@ -226,13 +1626,37 @@ func isErr(loc string, res C.size_t) error {
 	return xerrors.Errorf("zstdwrap.%s: %w", loc, errCode(code))
 }
 
+// ErrorCode is a zstd error code.
+//
+// Errors returned by this package wrap an *ErrorCode.
+// Use xerrors.Is to compare them against the sentinel values.
 type ErrorCode int
 
+// Error reports the zstd name of the error with its numeric
+// code, as in "Corruption detected (code 20)".
 func (code *ErrorCode) Error() string {
 	if code == nil {
 		return "zstdwrap.ErrorCode(nil)"
 	}
-	return C.GoString(C.ZSTD_getErrorString(C.ZSTD_ErrorCode(*code)))
+	return fmt.Sprintf("%s (code %d)", C.GoString(C.ZSTD_getErrorString(C.ZSTD_ErrorCode(*code))), int(*code))
+}
+
+// Code reports the numeric ZSTD_ErrorCode, for logging and
+// metrics that need it even when no sentinel matches.
+func (code *ErrorCode) Code() int {
+	if code == nil {
+		return 0
+	}
+	return int(*code)
+}
+
+// Is reports whether target is an *ErrorCode with the same code.
+func (code *ErrorCode) Is(target error) bool {
+	t, ok := target.(*ErrorCode)
+	if !ok || code == nil || t == nil {
+		return false
+	}
+	return *code == *t
 }
 
 // Zstd stable error codes.
@@ -261,7 +1685,11 @@ var (
 	ErrDstBufferNull                = errCode(74)
 )
 
+// errCode interns error codes, so the sentinels above and
+// the errors produced by isErr share pointers.
 func errCode(code int) *ErrorCode {
+	knownErrCodesMu.Lock()
+	defer knownErrCodesMu.Unlock()
 	if e := knownErrCodes[code]; e != nil {
 		return e
 	}
@@ -271,4 +1699,7 @@ func errCode(code int) *ErrorCode {
 }
 
 // Avoid allocating errors.
-var knownErrCodes = make(map[int]*ErrorCode)
+var (
+	knownErrCodesMu sync.Mutex
+	knownErrCodes   = make(map[int]*ErrorCode)
+)
@@ -16,8 +16,16 @@ package zstdwrap_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/crawshaw/zstdwrap"
 	"golang.org/x/xerrors"
@@ -111,3 +119,1823 @@ func Test(t *testing.T) {
 		}
 	})
 }
+
+func TestDictionary(t *testing.T) {
+	dict := []byte(`{"name":"","email":"","address":{"street":"","city":"","country":""},"tags":[]}`)
+	records := []string{
+		`{"name":"alice","email":"alice@example.com","address":{"street":"1 Main St","city":"Springfield","country":"US"},"tags":[]}`,
+		`{"name":"bob","email":"bob@example.com","address":{"street":"2 Main St","city":"Shelbyville","country":"US"},"tags":[]}`,
+	}
+
+	plain, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Delete()
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Dictionary: dict})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.LoadDictionary(dict); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dictionary is sticky across calls to
+	// Compress and Decompress.
+	for _, rec := range records {
+		withoutDict, err := plain.Compress(nil, []byte(rec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		withDict, err := c.Compress(nil, []byte(rec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(withDict) >= len(withoutDict) {
+			t.Errorf("dictionary did not help: len=%d, without dictionary len=%d", len(withDict), len(withoutDict))
+		}
+		got, err := d.Decompress(nil, withDict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != rec {
+			t.Errorf("round trip mismatch: %q", got)
+		}
+	}
+}
+
+func TestDigestedDictionary(t *testing.T) {
+	dict := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 10))
+	src := "The quick brown fox jumps over the lazy cat."
+
+	cdict, err := zstdwrap.NewCDict(dict, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cdict.Delete()
+	ddict, err := zstdwrap.NewDDict(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ddict.Delete()
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.RefDDict(ddict); err != nil {
+		t.Fatal(err)
+	}
+
+	// Many compressors share one CDict.
+	for i := 0; i < 3; i++ {
+		c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CDict: cdict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed, err := c.Compress(nil, []byte(src))
+		c.Delete()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.Decompress(nil, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src {
+			t.Errorf("round trip mismatch: %q", got)
+		}
+	}
+
+	_, err = zstdwrap.NewCompressor(&zstdwrap.COptions{Dictionary: dict, CDict: cdict})
+	if err == nil {
+		t.Error("NewCompressor accepted both Dictionary and CDict")
+	}
+}
+
+func TestCompressorReset(t *testing.T) {
+	src := []byte(strings.Repeat("Hello, World!\n", 20))
+	dict := []byte(strings.Repeat("Hello, World!\n", 2))
+
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Dictionary: dict})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if err := d.LoadDictionary(dict); err != nil {
+		t.Fatal(err)
+	}
+
+	withDict, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Reset(zstdwrap.ResetSessionOnly); err != nil {
+		t.Fatal(err)
+	}
+	afterSession, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(afterSession, withDict) {
+		t.Error("session reset changed output, want dictionary kept")
+	}
+	if got, err := d.Decompress(nil, afterSession); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, src) {
+		t.Errorf("round trip mismatch: %q", got)
+	}
+
+	if err := c.Reset(zstdwrap.ResetSessionAndParameters); err != nil {
+		t.Fatal(err)
+	}
+	afterParams, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(afterParams, withDict) {
+		t.Error("parameter reset did not drop dictionary")
+	}
+	if got, err := d.Decompress(nil, afterParams); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, src) {
+		t.Errorf("round trip mismatch: %q", got)
+	}
+}
+
+func TestDecompressorReset(t *testing.T) {
+	src := []byte(strings.Repeat("Hello, World!\n", 20) + strings.Repeat("Goodbye, World!\n", 20))
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	good, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := append([]byte{}, good...)
+	bad[len(bad)-2] ^= 0xff // damage the sequences section
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	_, err = d.Decompress(nil, bad)
+	if !xerrors.Is(err, zstdwrap.ErrCorruptionDetected) {
+		t.Fatalf("Decompress(bad) err=%v, want ErrCorruptionDetected", err)
+	}
+	code := zstdwrap.ErrorCode(20)
+	if !xerrors.Is(err, &code) {
+		t.Errorf("Decompress(bad) err=%v does not match ErrorCode(20)", err)
+	}
+	var target *zstdwrap.ErrorCode
+	if !xerrors.As(err, &target) || target != zstdwrap.ErrCorruptionDetected {
+		t.Errorf("xerrors.As(%v)=%v, want ErrCorruptionDetected", err, target)
+	}
+	if got := target.Code(); got != 20 {
+		t.Errorf("Code()=%d, want 20", got)
+	}
+	if got, want := target.Error(), "Corrupted block detected (code 20)"; got != want {
+		t.Errorf("Error()=%q, want %q", got, want)
+	}
+	unknown := zstdwrap.ErrorCode(99)
+	if got, want := unknown.Error(), "Unspecified error code (code 99)"; got != want {
+		t.Errorf("ErrorCode(99).Error()=%q, want %q", got, want)
+	}
+	if err := d.Reset(zstdwrap.ResetSessionOnly); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decompress(nil, good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Errorf("round trip mismatch: %q", got)
+	}
+}
+
+func TestCompressBound(t *testing.T) {
+	sizes := []int{0, 1, 2, 255, 256, 257, 2047, 2048, 4096, 65535}
+	for n := 128<<10 - 4096; n <= 128<<10+4096; n += 511 {
+		sizes = append(sizes, n)
+	}
+	sizes = append(sizes, 128<<10-1, 128<<10, 128<<10+1, 1<<20, 1<<30)
+	for _, n := range sizes {
+		if got, want := zstdwrap.CompressBound(n), zstdwrap.CompressBoundC(n); got != want {
+			t.Errorf("CompressBound(%d)=%d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	for _, src := range [][]byte{nil, {}} {
+		dst, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sz, err := zstdwrap.FrameCompressedSize(dst); err != nil {
+			t.Fatal(err)
+		} else if sz != len(dst) {
+			t.Errorf("FrameCompressedSize=%d, want %d", sz, len(dst))
+		}
+		if sz, err := zstdwrap.FrameContentSize(dst); err != nil {
+			t.Fatal(err)
+		} else if sz != 0 {
+			t.Errorf("FrameContentSize=%d, want 0", sz)
+		}
+
+		got, err := d.Decompress(nil, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || len(got) != 0 {
+			t.Errorf("Decompress=%#v, want empty non-nil slice", got)
+		}
+	}
+}
+
+func TestWindowLogMax(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	small, err := c.Compress(nil, bytes.Repeat([]byte("a"), 1<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	big, err := c.Compress(nil, bytes.Repeat([]byte("a"), 1<<10+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := zstdwrap.NewDecompressor(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if _, err := d.Decompress(nil, small); err != nil {
+		t.Errorf("Decompress(1<<10 bytes): %v", err)
+	}
+	_, err = d.Decompress(nil, big)
+	if !xerrors.Is(err, zstdwrap.ErrFrameTooBig) {
+		t.Fatalf("Decompress(1<<10+1 bytes) err=%v, want ErrFrameTooBig", err)
+	}
+	var tooBig *zstdwrap.FrameTooBigError
+	if !xerrors.As(err, &tooBig) {
+		t.Fatalf("Decompress err=%v, not a *FrameTooBigError", err)
+	}
+	if tooBig.Size != 1<<10+1 || tooBig.Limit != 1<<10 {
+		t.Errorf("FrameTooBigError{Size: %d, Limit: %d}, want {%d, %d}", tooBig.Size, tooBig.Limit, 1<<10+1, 1<<10)
+	}
+
+	// Without a recorded content size, the limit is
+	// found while decoding.
+	d21, err := zstdwrap.NewDecompressor(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d21.Delete()
+	streamed := compressStream(t, strings.Repeat("a", 1<<21+1))
+	if _, err := d21.Decompress(nil, streamed); !xerrors.Is(err, zstdwrap.ErrFrameTooBig) {
+		t.Errorf("Decompress(streamed) err=%v, want ErrFrameTooBig", err)
+	}
+}
+
+func TestDecompressUnknownSize(t *testing.T) {
+	src := strings.Repeat("Hello, World!\n", 20000)
+	frame := compressStream(t, src)
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	for _, dst := range [][]byte{nil, make([]byte, 0, 10), make([]byte, 0, len(src))} {
+		got, err := d.Decompress(dst, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src {
+			t.Errorf("round trip mismatch: len=%d, want %d", len(got), len(src))
+		}
+	}
+
+	if _, err := d.Decompress(nil, frame[:len(frame)-4]); !xerrors.Is(err, zstdwrap.ErrSrcSizeWrong) {
+		t.Errorf("Decompress(truncated) err=%v, want ErrSrcSizeWrong", err)
+	}
+
+	small, err := zstdwrap.NewDecompressor(17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer small.Delete()
+	if _, err := small.Decompress(nil, frame); err == nil {
+		t.Errorf("Decompress of %d bytes succeeded with windowLogMax 17", len(src))
+	}
+}
+
+func TestDecompressBound(t *testing.T) {
+	src1 := strings.Repeat("Hello, World!\n", 20)
+	src2 := strings.Repeat("Goodbye, World!\n", 300)
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	frame1, err := c.Compress(nil, []byte(src1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame2 := compressStream(t, src2)
+
+	tests := []struct {
+		name string
+		src  []byte
+		min  int
+	}{
+		{"single", frame1, len(src1)},
+		{"unknown-size", frame2, len(src2)},
+		{"concatenated", append(append([]byte{}, frame1...), frame2...), len(src1) + len(src2)},
+	}
+	for _, test := range tests {
+		sz, err := zstdwrap.DecompressBound(test.src)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if sz < int64(test.min) {
+			t.Errorf("%s: DecompressBound=%d, want >= %d", test.name, sz, test.min)
+		}
+	}
+
+	if _, err := zstdwrap.DecompressBound(frame1[:len(frame1)-1]); err != zstdwrap.ErrBadFrame {
+		t.Errorf("DecompressBound(truncated) err=%v, want ErrBadFrame", err)
+	}
+}
+
+func TestAdvancedParameters(t *testing.T) {
+	src := strings.Repeat("Hello, World!\n", 2000)
+	for _, windowLog := range []int{17, 20} {
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, &zstdwrap.COptions{
+			WindowLog: windowLog,
+			HashLog:   16,
+			ChainLog:  16,
+			SearchLog: 2,
+			MinMatch:  5,
+			Strategy:  zstdwrap.StrategyLazy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, src)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// RFC 8478 section 3.1.1.1.2. A streamed frame
+		// of unknown size is not single-segment, so the
+		// header has a window descriptor.
+		dst := buf.Bytes()
+		if singleSegment := dst[4]&0x20 != 0; singleSegment {
+			t.Fatal("unexpected single-segment frame")
+		}
+		if exp := 10 + int(dst[5]>>3); exp != windowLog {
+			t.Errorf("window descriptor exponent=%d, want %d", exp, windowLog)
+		}
+
+		d, err := zstdwrap.NewDecompressor(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.Decompress(nil, dst)
+		d.Delete()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src {
+			t.Error("round trip mismatch")
+		}
+	}
+
+	_, err := zstdwrap.NewCompressor(&zstdwrap.COptions{WindowLog: 99})
+	if !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+		t.Errorf("NewCompressor(WindowLog: 99) err=%v, want ErrParameterOutOfBound", err)
+	}
+}
+
+func TestLongDistanceMatching(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 4mb compression in short mode")
+	}
+	block := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(block)
+	src := bytes.Repeat(block, 4)
+
+	compress := func(opts *zstdwrap.COptions) []byte {
+		t.Helper()
+		c, err := zstdwrap.NewCompressor(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Delete()
+		dst, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dst
+	}
+
+	// Level 1 uses a window smaller than the 1mb
+	// distance between repeats.
+	without := compress(&zstdwrap.COptions{CompressionLevel: 1})
+	with := compress(&zstdwrap.COptions{
+		CompressionLevel:           1,
+		EnableLongDistanceMatching: true,
+	})
+	if len(with) > len(without)/2 {
+		t.Errorf("LDM len=%d, without LDM len=%d, want less than half", len(with), len(without))
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	got, err := d.Decompress(nil, with)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Error("round trip mismatch")
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	src := []byte(strings.Repeat("Hello, World!\n", 1<<18)) // 3.5mb
+
+	compress := func(opts *zstdwrap.COptions) []byte {
+		t.Helper()
+		c, err := zstdwrap.NewCompressor(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Delete()
+		dst, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dst
+	}
+
+	if !bytes.Equal(compress(nil), compress(&zstdwrap.COptions{NBWorkers: 0})) {
+		t.Error("NBWorkers: 0 changed the output")
+	}
+
+	dst := compress(&zstdwrap.COptions{
+		NBWorkers:  2,
+		JobSize:    1 << 20,
+		OverlapLog: 5,
+	})
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	got, err := d.Decompress(nil, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Error("round trip mismatch")
+	}
+}
+
+func TestMagicless(t *testing.T) {
+	src := []byte(strings.Repeat("Hello, World!\n", 20))
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Format: zstdwrap.FormatZstd1Magicless})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	dst, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if magic := []byte{0x28, 0xB5, 0x2F, 0xFD}; bytes.Equal(dst[:4], magic) {
+		t.Errorf("magicless frame starts with magic %x", dst[:4])
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if _, err := d.Decompress(nil, dst); err == nil {
+		t.Error("default Decompressor decoded a magicless frame")
+	}
+	if err := d.SetFormat(zstdwrap.FormatZstd1Magicless); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decompress(nil, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Errorf("round trip mismatch: %q", got)
+	}
+}
+
+func TestContentSizeFlag(t *testing.T) {
+	src := []byte(strings.Repeat("Hello, World!\n", 20))
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	for _, tt := range []struct {
+		name string
+		opts zstdwrap.COptions
+		want bool // frame header records the content size
+	}{
+		{"default", zstdwrap.COptions{}, true},
+		{"ContentSizeFlag", zstdwrap.COptions{ContentSizeFlag: true}, true},
+		{"NoContentSize", zstdwrap.COptions{NoContentSize: true}, false},
+		{"NoContentSize-stored", zstdwrap.COptions{NoContentSize: true, SkipCompressionBelow: 1 << 10}, false},
+	} {
+		c, err := zstdwrap.NewCompressor(&tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Delete()
+		c2, err := c.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c2.Delete()
+
+		for name, c := range map[string]*zstdwrap.Compressor{tt.name: c, tt.name + "-clone": c2} {
+			frame, err := c.Compress(nil, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hdr, err := zstdwrap.ReadFrameHeader(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.HasContentSize != tt.want {
+				t.Errorf("%s: HasContentSize=%v, want %v", name, hdr.HasContentSize, tt.want)
+			} else if tt.want && hdr.ContentSize != int64(len(src)) {
+				t.Errorf("%s: ContentSize=%d, want %d", name, hdr.ContentSize, len(src))
+			}
+			if got, err := d.Decompress(nil, frame); err != nil || !bytes.Equal(got, src) {
+				t.Errorf("%s: round trip failed: %v", name, err)
+			}
+		}
+
+		// A pledged size is recorded by a Writer in the same way.
+		buf := new(bytes.Buffer)
+		w, err := zstdwrap.NewWriter(buf, &tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetPledgedSrcSize(int64(len(src))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(src); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := zstdwrap.ReadFrameHeader(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.HasContentSize != tt.want {
+			t.Errorf("%s: Writer HasContentSize=%v, want %v", tt.name, hdr.HasContentSize, tt.want)
+		}
+	}
+
+	_, err = zstdwrap.NewCompressor(&zstdwrap.COptions{ContentSizeFlag: true, NoContentSize: true})
+	if err == nil {
+		t.Error("ContentSizeFlag and NoContentSize both set: no error")
+	}
+}
+
+func TestReadFrameHeader(t *testing.T) {
+	src := strings.Repeat("Hello, World!\n", 20)
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	sized, err := c.Compress(nil, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdr, err := zstdwrap.ReadFrameHeader(sized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hdr.HasContentSize || hdr.ContentSize != int64(len(src)) {
+		t.Errorf("ContentSize=%d (%v), want %d", hdr.ContentSize, hdr.HasContentSize, len(src))
+	}
+	if !hdr.Checksum {
+		t.Error("missing checksum")
+	}
+	if hdr.DictID != 0 {
+		t.Errorf("DictID=%d, want 0", hdr.DictID)
+	}
+	if hdr.WindowSize < int64(len(src)) {
+		t.Errorf("WindowSize=%d, want >= %d", hdr.WindowSize, len(src))
+	}
+	if hdr.HeaderSize < 6 || hdr.HeaderSize > len(sized) {
+		t.Errorf("HeaderSize=%d", hdr.HeaderSize)
+	}
+
+	hdr, err = zstdwrap.ReadFrameHeader(compressStream(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.HasContentSize || hdr.Checksum {
+		t.Errorf("streamed frame header=%+v, want no content size or checksum", hdr)
+	}
+
+	_, err = zstdwrap.ReadFrameHeader(sized[:5])
+	var needMore *zstdwrap.NeedMoreError
+	if !xerrors.As(err, &needMore) {
+		t.Fatalf("ReadFrameHeader(truncated) err=%v, want NeedMoreError", err)
+	}
+	if needMore.Need <= 5 || needMore.Need > 18 {
+		t.Errorf("NeedMoreError.Need=%d", needMore.Need)
+	}
+	if _, err := zstdwrap.ReadFrameHeader(sized[:needMore.Need]); err != nil {
+		t.Errorf("ReadFrameHeader(%d bytes): %v", needMore.Need, err)
+	}
+
+	if _, err := zstdwrap.ReadFrameHeader([]byte("not a zstd frame")); !xerrors.Is(err, zstdwrap.ErrPrefixUnknown) {
+		t.Errorf("ReadFrameHeader(garbage) err=%v, want ErrPrefixUnknown", err)
+	}
+}
+
+func TestReadFrameHeaderFrom(t *testing.T) {
+	src := strings.Repeat("Hello, World!\n", 20)
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	frame, err := c.Compress(nil, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := zstdwrap.ReadFrameHeader(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(frame)
+	hdr, prefix, err := zstdwrap.ReadFrameHeaderFrom(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr != want {
+		t.Errorf("header=%+v, want %+v", hdr, want)
+	}
+	if len(prefix) != want.HeaderSize || !bytes.Equal(prefix, frame[:len(prefix)]) {
+		t.Errorf("consumed %d bytes, want the %d header bytes", len(prefix), want.HeaderSize)
+	}
+	if r.Len() != len(frame)-want.HeaderSize {
+		t.Errorf("read %d bytes from r, want %d", len(frame)-r.Len(), want.HeaderSize)
+	}
+	got, err := ioutil.ReadAll(io.MultiReader(bytes.NewReader(prefix), r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := zstdwrap.Decompress(nil, got); err != nil || string(out) != src {
+		t.Errorf("decompress after putting back the prefix: %v", err)
+	}
+
+	for _, n := range []int{0, 3, want.HeaderSize - 1} {
+		_, prefix, err := zstdwrap.ReadFrameHeaderFrom(iotest.OneByteReader(bytes.NewReader(frame[:n])))
+		var needMore *zstdwrap.NeedMoreError
+		if !xerrors.As(err, &needMore) || needMore.Need <= n || needMore.Need > want.HeaderSize {
+			t.Errorf("%d bytes: err=%v, want NeedMoreError up to %d", n, err, want.HeaderSize)
+		}
+		if len(prefix) != n {
+			t.Errorf("%d bytes: prefix of %d bytes", n, len(prefix))
+		}
+	}
+}
+
+func TestFrameSizes(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	var buf []byte
+	var want []int
+	for _, src := range []string{"one", strings.Repeat("two", 100), strings.Repeat("three", 1000)} {
+		frame, err := c.Compress(nil, []byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(buf, frame...)
+		want = append(want, len(frame))
+	}
+
+	sizes, rest, err := zstdwrap.FrameSizes(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(sizes) != fmt.Sprint(want) || rest != 0 {
+		t.Errorf("FrameSizes=%v, %d, want %v, 0", sizes, rest, want)
+	}
+
+	sizes, rest, err = zstdwrap.FrameSizes(buf[:len(buf)-3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(sizes) != fmt.Sprint(want[:2]) || rest != want[2]-3 {
+		t.Errorf("FrameSizes(truncated)=%v, %d, want %v, %d", sizes, rest, want[:2], want[2]-3)
+	}
+
+	garbage := append(append([]byte{}, buf...), "not a zstd frame"...)
+	if _, _, err := zstdwrap.FrameSizes(garbage); !xerrors.Is(err, zstdwrap.ErrPrefixUnknown) {
+		t.Errorf("FrameSizes(garbage) err=%v, want ErrPrefixUnknown", err)
+	}
+}
+
+func TestCompressionLevelBounds(t *testing.T) {
+	min, max := zstdwrap.MinCompressionLevel(), zstdwrap.MaxCompressionLevel()
+	if def := zstdwrap.DefaultCompressionLevel(); def < min || def > max {
+		t.Errorf("DefaultCompressionLevel()=%d, not in [%d, %d]", def, min, max)
+	}
+	if max < 19 {
+		t.Errorf("MaxCompressionLevel()=%d, want at least 19", max)
+	}
+
+	for _, level := range []int{min, max} {
+		c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CompressionLevel: level})
+		if err != nil {
+			t.Errorf("level %d: %v", level, err)
+			continue
+		}
+		c.Delete()
+	}
+	for _, level := range []int{min - 1, max + 1} {
+		if _, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CompressionLevel: level}); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+			t.Errorf("level %d: err=%v, want ErrParameterOutOfBound", level, err)
+		}
+		if _, err := zstdwrap.Compress(nil, []byte("hello"), level); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+			t.Errorf("Compress level %d: err=%v, want ErrParameterOutOfBound", level, err)
+		}
+	}
+}
+
+func TestNegativeLevel(t *testing.T) {
+	src := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog. ", 2000))
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < len(src); i += 97 {
+		src[i] = byte('a' + rng.Intn(26))
+	}
+
+	compress := func(level int) []byte {
+		t.Helper()
+		c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{CompressionLevel: level})
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		defer c.Delete()
+		out, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		return out
+	}
+
+	fast := compress(-5)
+	strong := compress(19)
+	if len(fast) <= len(strong) {
+		t.Errorf("level -5 output %d bytes, level 19 output %d bytes, want level -5 larger", len(fast), len(strong))
+	}
+	pooled, err := zstdwrap.Compress(nil, src, -5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pooled, fast) {
+		t.Error("package Compress at level -5 differs from Compressor")
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	got, err := d.Decompress(nil, fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Error("level -5 round trip mismatch")
+	}
+}
+
+func TestCompressInto(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+
+	src := []byte(strings.Repeat("hello, world. ", 1000))
+	want, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 0, zstdwrap.CompressBound(len(src)))
+	n, err := c.CompressInto(buf, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], want) {
+		t.Error("CompressInto output differs from Compress")
+	}
+	if allocs := testing.AllocsPerRun(10, func() { c.CompressInto(buf, src) }); allocs != 0 {
+		t.Errorf("CompressInto allocated %v times, want 0", allocs)
+	}
+
+	small := make([]byte, len(want)-1)
+	if _, err := c.CompressInto(small, src); !xerrors.Is(err, zstdwrap.ErrDstSizeTooSmall) {
+		t.Errorf("CompressInto(small) err=%v, want ErrDstSizeTooSmall", err)
+	}
+	if _, err := c.CompressInto(nil, src); !xerrors.Is(err, zstdwrap.ErrDstSizeTooSmall) {
+		t.Errorf("CompressInto(nil) err=%v, want ErrDstSizeTooSmall", err)
+	}
+}
+
+func TestDecompressInto(t *testing.T) {
+	src := []byte(strings.Repeat("hello, world. ", 1000))
+	known, err := zstdwrap.Compress(nil, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := compressStream(t, string(src))
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	for _, tt := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"KnownSize", known},
+		{"UnknownSize", unknown},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, 0, len(src))
+			n, err := d.DecompressInto(buf, tt.frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], src) {
+				t.Error("round trip mismatch")
+			}
+			if allocs := testing.AllocsPerRun(10, func() { d.DecompressInto(buf, tt.frame) }); allocs != 0 {
+				t.Errorf("DecompressInto allocated %v times, want 0", allocs)
+			}
+
+			small := make([]byte, len(src)-1)
+			if _, err := d.DecompressInto(small, tt.frame); !xerrors.Is(err, zstdwrap.ErrDstSizeTooSmall) {
+				t.Errorf("DecompressInto(small) err=%v, want ErrDstSizeTooSmall", err)
+			}
+		})
+	}
+}
+
+func TestIgnoreChecksum(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	src := []byte(strings.Repeat("hello, world. ", 100))
+	frame, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame[len(frame)-1] ^= 0xff // last byte of the checksum
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if _, err := d.Decompress(nil, frame); !xerrors.Is(err, zstdwrap.ErrChecksumWrong) {
+		t.Fatalf("Decompress err=%v, want ErrChecksumWrong", err)
+	}
+
+	d.SetIgnoreChecksum(true)
+	got, err := d.Decompress(nil, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Error("round trip mismatch")
+	}
+	buf := make([]byte, len(src))
+	if n, err := d.DecompressInto(buf, frame); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf[:n], src) {
+		t.Error("DecompressInto round trip mismatch")
+	}
+
+	d.SetIgnoreChecksum(false)
+	if _, err := d.Decompress(nil, frame); !xerrors.Is(err, zstdwrap.ErrChecksumWrong) {
+		t.Errorf("Decompress err=%v, want ErrChecksumWrong", err)
+	}
+}
+
+func TestRsyncable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping rsyncable test in short mode")
+	}
+	words := strings.Fields("alpha bravo charlie delta echo foxtrot golf hotel india juliet kilo lima mike november oscar papa")
+	rng := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for buf.Len() < 4<<20 {
+		buf.WriteString(words[rng.Intn(len(words))])
+		buf.WriteByte(" \n"[rng.Intn(2)])
+	}
+	src1 := buf.Bytes()
+	mid := len(src1) / 4
+	src2 := append(append(append([]byte{}, src1[:mid]...), 'X'), src1[mid:]...)
+
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+		CompressionLevel: 1,
+		NBWorkers:        2,
+		JobSize:          1 << 20,
+		Rsyncable:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	out1, err := c.Compress(nil, src1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out2, err := c.Compress(nil, src2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suffix := 0
+	for suffix < len(out1) && suffix < len(out2) && out1[len(out1)-1-suffix] == out2[len(out2)-1-suffix] {
+		suffix++
+	}
+	if suffix < len(out1)/2 {
+		t.Errorf("outputs share a %d byte suffix of %d bytes, want at least half", suffix, len(out1))
+	}
+
+	got, err := zstdwrap.Decompress(nil, out2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src2) {
+		t.Error("round trip mismatch")
+	}
+}
+
+func TestIsCompleteFrame(t *testing.T) {
+	frame, err := zstdwrap.Compress(nil, []byte(strings.Repeat("hello, world. ", 100)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	two := append(append([]byte{}, frame...), frame...)
+	for _, tt := range []struct {
+		name string
+		src  []byte
+		want bool
+	}{
+		{"Frame", frame, true},
+		{"TwoFrames", two, true},
+		{"Truncated", frame[:len(frame)-1], false},
+		{"TrailingPartial", two[:len(two)-3], false},
+		{"TrailingGarbage", append(append([]byte{}, frame...), "junk"...), false},
+		{"Empty", nil, false},
+	} {
+		if got := zstdwrap.IsCompleteFrame(tt.src); got != tt.want {
+			t.Errorf("%s: IsCompleteFrame=%v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompressMulti(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	srcs := [][]byte{
+		[]byte("first"),
+		[]byte(strings.Repeat("second ", 1000)),
+		{},
+	}
+	dst, offsets, err := c.CompressMulti(nil, srcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != len(srcs) || offsets[0] != 0 {
+		t.Fatalf("offsets=%v", offsets)
+	}
+	sizes, _, err := zstdwrap.FrameSizes(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != len(srcs) {
+		t.Fatalf("FrameSizes found %d frames, want %d", len(sizes), len(srcs))
+	}
+	for i, src := range srcs {
+		end := len(dst)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		if end-offsets[i] != sizes[i] {
+			t.Errorf("frame %d: offsets give %d bytes, FrameSizes %d", i, end-offsets[i], sizes[i])
+		}
+		got, err := zstdwrap.Decompress(nil, dst[offsets[i]:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("frame %d: round trip mismatch", i)
+		}
+	}
+}
+
+func TestTotalContentSize(t *testing.T) {
+	src1 := []byte(strings.Repeat("first ", 100))
+	src2 := []byte(strings.Repeat("second ", 1000))
+	frame1, err := zstdwrap.Compress(nil, src1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame2, err := zstdwrap.Compress(nil, src2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skippable, err := zstdwrap.WriteSkippableFrame(nil, 0, []byte("metadata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := append(append(append([]byte{}, frame1...), skippable...), frame2...)
+
+	total, err := zstdwrap.TotalContentSize(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(src1) + len(src2)); total != want {
+		t.Errorf("TotalContentSize=%d, want %d", total, want)
+	}
+
+	unknown := append(append([]byte{}, frame1...), compressStream(t, "streamed")...)
+	if _, err := zstdwrap.TotalContentSize(unknown); err != zstdwrap.ErrContentSizeUnknown {
+		t.Errorf("TotalContentSize err=%v, want ErrContentSizeUnknown", err)
+	}
+	if _, err := zstdwrap.TotalContentSize(buf[:len(buf)-1]); !xerrors.Is(err, zstdwrap.ErrSrcSizeWrong) {
+		t.Errorf("TotalContentSize(truncated) err=%v, want ErrSrcSizeWrong", err)
+	}
+}
+
+func TestMaxWindowSizeBytes(t *testing.T) {
+	for _, tt := range []struct {
+		size int64
+		want int
+	}{
+		{1 << 10, 10},
+		{1 << 20, 20},
+		{1<<20 + 1, 21},
+		{3 << 20, 22},
+	} {
+		d, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{MaxWindowSizeBytes: tt.size})
+		if err != nil {
+			t.Fatalf("MaxWindowSizeBytes %d: %v", tt.size, err)
+		}
+		if got, err := d.GetParameter(zstdwrap.DParamWindowLogMax); err != nil {
+			t.Error(err)
+		} else if got != tt.want {
+			t.Errorf("MaxWindowSizeBytes %d: windowLogMax=%d, want %d", tt.size, got, tt.want)
+		}
+		d.Delete()
+	}
+
+	for _, size := range []int64{-1, 1<<10 - 1, 1 << 40} {
+		if _, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{MaxWindowSizeBytes: size}); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+			t.Errorf("MaxWindowSizeBytes %d: err=%v, want ErrParameterOutOfBound", size, err)
+		}
+	}
+	if _, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{WindowLogMax: 20, MaxWindowSizeBytes: 1 << 20}); err == nil {
+		t.Error("WindowLogMax with MaxWindowSizeBytes succeeded")
+	}
+
+	// A 2mb window frame is refused by a 1mb limit.
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{WindowLog: 21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+	src := make([]byte, 3<<20)
+	rand.New(rand.NewSource(1)).Read(src[:1<<20])
+	copy(src[2<<20:], src[:1<<20])
+	frame, err := c.Compress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{MaxWindowSizeBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	if _, err := d.Decompress(nil, frame); err == nil {
+		t.Error("Decompress with a too small window succeeded")
+	}
+}
+
+func TestDecompressReuseBuffer(t *testing.T) {
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	buf := make([]byte, 0, 64<<10)
+	for _, n := range []int{50 << 10, 10, 0, 64 << 10, 3000} {
+		src := bytes.Repeat([]byte{byte(n)}, n)
+		frame, err := zstdwrap.Compress(nil, src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.Decompress(buf, frame)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("%d bytes: round trip mismatch, len=%d", n, len(got))
+		}
+		if cap(got) != cap(buf) || (n > 0 && &got[0] != &buf[:1][0]) {
+			t.Errorf("%d bytes: dst reallocated", n)
+		}
+	}
+
+	// A buffer too small is replaced, and left unmodified.
+	small := []byte("unchanged")
+	frame, err := zstdwrap.Compress(nil, bytes.Repeat([]byte("x"), 100), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decompress(small[:0], frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 100 || string(small) != "unchanged" {
+		t.Errorf("len(got)=%d, small=%q", len(got), small)
+	}
+
+	if _, err := d.Decompress(nil, []byte{}); err == nil {
+		t.Error("Decompress of empty src succeeded")
+	}
+	if _, err := zstdwrap.FrameContentSize([]byte{}); err == nil {
+		t.Error("FrameContentSize of empty src succeeded")
+	}
+}
+
+func TestClone(t *testing.T) {
+	dict := []byte(`{"name":"","email":"","address":{"street":"","city":"","country":""},"tags":[]}`)
+	rec := []byte(`{"name":"alice","email":"alice@example.com","address":{"street":"1 Main St","city":"Springfield","country":"US"},"tags":[]}`)
+
+	for _, byRef := range []bool{false, true} {
+		c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{
+			CompressionLevel:      19,
+			Checksum:              true,
+			WindowLog:             20,
+			Dictionary:            dict,
+			DictionaryByReference: byRef,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Delete()
+		c2, err := c.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c2.Delete()
+
+		for _, p := range []zstdwrap.CParameter{zstdwrap.CParamCompressionLevel, zstdwrap.CParamChecksumFlag, zstdwrap.CParamWindowLog} {
+			v, _ := c.GetParameter(p)
+			v2, err := c2.GetParameter(p)
+			if err != nil || v != v2 {
+				t.Errorf("byRef=%v: parameter %d: clone=%d, %v, want %d", byRef, p, v2, err, v)
+			}
+		}
+		want, err := c.Compress(nil, rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c2.Compress(nil, rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("byRef=%v: clone output differs", byRef)
+		}
+
+		// The original is unaffected by deleting the clone.
+		c2.Delete()
+		if got, err := c.Compress(nil, rec); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("byRef=%v: compress after clone Delete: %v", byRef, err)
+		}
+	}
+}
+
+func TestDecompressFrameAt(t *testing.T) {
+	msgs := []string{"frame zero", strings.Repeat("frame one ", 1000), "frame two"}
+	var buf []byte
+	for _, msg := range msgs {
+		frame, err := zstdwrap.Compress(nil, []byte(msg), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(buf, frame...)
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	next := 0
+	for i, msg := range msgs {
+		var got []byte
+		got, next, err = d.DecompressFrameAt(nil, buf, next)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if string(got) != msg {
+			t.Errorf("frame %d: got %d bytes, want %q", i, len(got), msg[:10])
+		}
+	}
+	if next != len(buf) {
+		t.Errorf("next=%d after last frame, want %d", next, len(buf))
+	}
+	if _, n, err := d.DecompressFrameAt(nil, buf, next); err == nil || n != next {
+		t.Errorf("DecompressFrameAt past the end: next=%d, err=%v", n, err)
+	}
+	if _, _, err := d.DecompressFrameAt(nil, buf, 1); err == nil {
+		t.Error("DecompressFrameAt in the middle of a frame succeeded")
+	}
+}
+
+func TestCompressAppend(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+
+	msgs := []string{"first", strings.Repeat("second ", 500), "", "fourth"}
+	buf := []byte("header")
+	for _, msg := range msgs {
+		if buf, err = c.CompressAppend(buf, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.HasPrefix(buf, []byte("header")) {
+		t.Fatalf("existing contents lost: %q", buf[:6])
+	}
+
+	stream := buf[len("header"):]
+	sizes, rest, err := zstdwrap.FrameSizes(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != len(msgs) || rest != 0 {
+		t.Fatalf("FrameSizes=%v, %d, want %d frames", sizes, rest, len(msgs))
+	}
+	for i, msg := range msgs {
+		got, err := zstdwrap.Decompress(nil, stream[:sizes[i]])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg {
+			t.Errorf("frame %d: got %d bytes, want %d", i, len(got), len(msg))
+		}
+		stream = stream[sizes[i]:]
+	}
+
+	// Enough spare capacity is used without allocating.
+	spare := make([]byte, 3, 3+zstdwrap.CompressBound(5))
+	out, err := c.CompressAppend(spare, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &out[0] != &spare[0] {
+		t.Error("CompressAppend reallocated dst with enough capacity")
+	}
+}
+
+func TestTruncatedFrame(t *testing.T) {
+	src := []byte(strings.Repeat("Hello, World!\n", 20) + strings.Repeat("Goodbye, World!\n", 20))
+	frame, err := zstdwrap.Compress(nil, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := new(bytes.Buffer)
+	zw, err := zstdwrap.NewWriter(w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(src); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	unknownSize := w.Bytes()
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	buf := make([]byte, 0, len(src))
+	for name, f := range map[string][]byte{"known size": frame, "unknown size": unknownSize} {
+		two := append(append([]byte{}, f...), f...)
+		for _, n := range []int{1, 4, 6, len(f) / 2, len(f) - 1, len(f) + 3} {
+			_, err := d.Decompress(nil, two[:n])
+			if !xerrors.Is(err, zstdwrap.ErrTruncatedFrame) || !xerrors.Is(err, zstdwrap.ErrSrcSizeWrong) {
+				t.Errorf("%s: Decompress(%d bytes) err=%v, want ErrTruncatedFrame", name, n, err)
+			}
+			_, err = d.DecompressInto(buf, two[:n])
+			if !xerrors.Is(err, zstdwrap.ErrTruncatedFrame) {
+				t.Errorf("%s: DecompressInto(%d bytes) err=%v, want ErrTruncatedFrame", name, n, err)
+			}
+		}
+		if _, _, err := d.DecompressFrameAt(nil, two[:len(two)-1], len(f)); !xerrors.Is(err, zstdwrap.ErrTruncatedFrame) {
+			t.Errorf("%s: DecompressFrameAt err=%v, want ErrTruncatedFrame", name, err)
+		}
+	}
+
+	bad := append([]byte{}, frame...)
+	bad[len(bad)-2] ^= 0xff // damage the sequences section
+	_, err = d.Decompress(nil, bad)
+	if !xerrors.Is(err, zstdwrap.ErrCorruptionDetected) || xerrors.Is(err, zstdwrap.ErrTruncatedFrame) {
+		t.Errorf("Decompress(corrupt) err=%v, want ErrCorruptionDetected", err)
+	}
+	if _, err := d.Decompress(nil, []byte("not a zstd frame")); xerrors.Is(err, zstdwrap.ErrTruncatedFrame) {
+		t.Errorf("Decompress(garbage) err=%v, want not ErrTruncatedFrame", err)
+	}
+}
+
+func TestDecompressNoRealloc(t *testing.T) {
+	src := []byte(strings.Repeat("mapped region contents. ", 5000))
+	known, err := zstdwrap.Compress(nil, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := zstdwrap.NewWriter(buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(src); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	unknown := buf.Bytes()
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	// region stands in for a memory-mapped file: a fixed
+	// backing array that must be written in place.
+	for _, extra := range []int{0, 1, 4096} {
+		region := make([]byte, len(src)+extra)
+		for name, frame := range map[string][]byte{"known size": known, "unknown size": unknown} {
+			out, err := d.Decompress(region[:0], frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, src) {
+				t.Errorf("%s, %d spare: round trip mismatch", name, extra)
+			}
+			if &out[0] != &region[0] {
+				t.Errorf("%s, %d spare: Decompress moved off the backing array", name, extra)
+			}
+
+			for i := range region {
+				region[i] = 0
+			}
+			n, err := d.DecompressInto(region, frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(region[:n], src) {
+				t.Errorf("%s, %d spare: DecompressInto round trip mismatch", name, extra)
+			}
+		}
+	}
+}
+
+func TestContentSizeCache(t *testing.T) {
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+
+	a := bytes.Repeat([]byte("a"), 1000)
+	frame, err := zstdwrap.Compress(nil, a, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := make([]byte, 0, 2000)
+	for i := 0; i < 100; i++ {
+		if _, err := d.Decompress(dst, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := zstdwrap.HeaderParses(d); n != 1 {
+		t.Errorf("decoding one frame 100 times parsed %d headers, want 1", n)
+	}
+
+	// The same buffer refilled with a frame of another
+	// size is not served from the cache.
+	b := bytes.Repeat([]byte("b"), 1500)
+	frameB, err := zstdwrap.Compress(nil, b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frameB) > cap(frame) {
+		t.Fatalf("frame for b is %d bytes, cannot reuse %d", len(frameB), cap(frame))
+	}
+	reused := frame[:len(frameB)]
+	copy(reused, frameB)
+	got, err := d.Decompress(dst, reused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, b) {
+		t.Errorf("reused buffer: got %d bytes, want %d", len(got), len(b))
+	}
+	if n := zstdwrap.HeaderParses(d); n != 2 {
+		t.Errorf("parsed %d headers, want 2", n)
+	}
+}
+
+func TestWindowLogBounds(t *testing.T) {
+	min, def, max := zstdwrap.WindowLogMin(), zstdwrap.WindowLogLimitDefault(), zstdwrap.WindowLogMax()
+	if !(min < def && def <= max) {
+		t.Errorf("WindowLogMin=%d, WindowLogLimitDefault=%d, WindowLogMax=%d, want min < default <= max", min, def, max)
+	}
+	for _, wl := range []int{min, def, max} {
+		d, err := zstdwrap.NewDecompressor(wl)
+		if err != nil {
+			t.Errorf("NewDecompressor(%d): %v", wl, err)
+			continue
+		}
+		d.Delete()
+	}
+	if _, err := zstdwrap.NewDecompressor(max + 1); !xerrors.Is(err, zstdwrap.ErrParameterOutOfBound) {
+		t.Errorf("NewDecompressor(%d) err=%v, want ErrParameterOutOfBound", max+1, err)
+	}
+}
+
+func TestDecompressMultiFrame(t *testing.T) {
+	msgs := []string{
+		strings.Repeat("small ", 10),
+		strings.Repeat("large frame content ", 20000),
+		strings.Repeat("medium ", 700),
+	}
+	var src []byte
+	for _, msg := range msgs {
+		frame, err := zstdwrap.Compress(nil, []byte(msg), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src = append(src, frame...)
+	}
+	want := strings.Join(msgs, "")
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	got, err := d.Decompress(nil, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+	if cap(got) != len(want) {
+		t.Errorf("cap(dst)=%d, want the total content size %d", cap(got), len(want))
+	}
+	buf := make([]byte, len(want))
+	if n, err := d.DecompressInto(buf, src); err != nil || string(buf[:n]) != want {
+		t.Errorf("DecompressInto: n=%d, err=%v", n, err)
+	}
+	if _, err := d.DecompressInto(buf[:len(want)-1:len(want)-1], src); !xerrors.Is(err, zstdwrap.ErrDstSizeTooSmall) {
+		t.Errorf("DecompressInto small dst: err=%v, want ErrDstSizeTooSmall", err)
+	}
+
+	// The window limit applies to each frame, not the total.
+	largest := int64(len(msgs[1]))
+	limited, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{MaxWindowSizeBytes: largest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limited.Delete()
+	if got, err := limited.Decompress(nil, src); err != nil || string(got) != want {
+		t.Errorf("limit of the largest frame: err=%v", err)
+	}
+	tooSmall, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{WindowLogMax: 17})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tooSmall.Delete()
+	_, err = tooSmall.Decompress(nil, src)
+	var tooBig *zstdwrap.FrameTooBigError
+	if !xerrors.As(err, &tooBig) || tooBig.Size != largest {
+		t.Errorf("limit below the largest frame: err=%v, want FrameTooBigError{Size: %d}", err, largest)
+	}
+}
+
+func TestFreeOnFinalize(t *testing.T) {
+	// waitGC collects garbage until the leak count reaches want,
+	// giving finalizers, which run on their own goroutine, time.
+	waitGC := func(want int64) int64 {
+		for i := 0; i < 50 && zstdwrap.Leaks() < want; i++ {
+			runtime.GC()
+			time.Sleep(time.Millisecond)
+		}
+		return zstdwrap.Leaks()
+	}
+	start := zstdwrap.Leaks()
+
+	// An explicit Delete leaves nothing for the finalizer to free.
+	func() {
+		c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{FreeOnFinalize: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Compress(nil, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		c.Delete()
+		d, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{FreeOnFinalize: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Delete()
+	}()
+	for i := 0; i < 5; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	if got := zstdwrap.Leaks(); got != start {
+		t.Fatalf("after Delete, finalizers freed %d contexts", got-start)
+	}
+
+	func() {
+		if _, err := zstdwrap.NewCompressor(&zstdwrap.COptions{FreeOnFinalize: true}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{FreeOnFinalize: true}); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if got := waitGC(start + 2); got != start+2 {
+		t.Errorf("finalizers freed %d leaked contexts, want 2", got-start)
+	}
+}
+
+func TestDecompressEach(t *testing.T) {
+	msgs := []string{
+		strings.Repeat("first ", 1000),
+		"second",
+		strings.Repeat("third ", 20000),
+	}
+	var src []byte
+	var total int
+	for i, msg := range msgs {
+		frame, err := zstdwrap.Compress(nil, []byte(msg), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src = append(src, frame...)
+		total += len(msg)
+		if i == 0 {
+			skippable, err := zstdwrap.WriteSkippableFrame(nil, 0, []byte("metadata"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			src = append(src, skippable...)
+		}
+	}
+
+	d, err := zstdwrap.NewDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Delete()
+	var sum, calls int
+	err = d.DecompressEach(src, func(frame []byte) error {
+		if string(frame) != msgs[calls] {
+			t.Errorf("frame %d: got %d bytes, want %d", calls, len(frame), len(msgs[calls]))
+		}
+		sum += len(frame)
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != len(msgs) || sum != total {
+		t.Errorf("%d calls summing %d bytes, want %d calls summing %d", calls, sum, len(msgs), total)
+	}
+
+	stop := errors.New("stop")
+	calls = 0
+	err = d.DecompressEach(src, func(frame []byte) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("fn error: err=%v after %d calls, want stop after 1", err, calls)
+	}
+
+	err = d.DecompressEach(src[:len(src)-1], func([]byte) error { return nil })
+	if !xerrors.Is(err, zstdwrap.ErrTruncatedFrame) {
+		t.Errorf("truncated: err=%v, want ErrTruncatedFrame", err)
+	}
+}
+
+func TestCompressedSize(t *testing.T) {
+	c, err := zstdwrap.NewCompressor(&zstdwrap.COptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Delete()
+
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	rnd.Read(random)
+	for _, src := range [][]byte{
+		nil,
+		[]byte("hello"),
+		[]byte(strings.Repeat("Hello, World!\n", 10000)),
+		random,
+		random[:1000],
+	} {
+		frame, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := c.CompressedSize(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(frame) {
+			t.Errorf("%d bytes: CompressedSize=%d, len(Compress)=%d", len(src), n, len(frame))
+		}
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { c.CompressedSize(random[:5000]) }); allocs != 0 {
+		t.Errorf("CompressedSize of a smaller src: %v allocs", allocs)
+	}
+}
+
+func TestDecompressorOptions(t *testing.T) {
+	src := []byte(strings.Repeat("hello, world. ", 100))
+	compress := func(opts *zstdwrap.COptions, src []byte) []byte {
+		t.Helper()
+		c, err := zstdwrap.NewCompressor(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Delete()
+		frame, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return frame
+	}
+	decompress := func(opts *zstdwrap.DOptions, frame []byte) ([]byte, error) {
+		t.Helper()
+		d, err := zstdwrap.NewDecompressorOpts(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Delete()
+		return d.Decompress(nil, frame)
+	}
+
+	t.Run("WindowLogMax", func(t *testing.T) {
+		big := bytes.Repeat([]byte("window"), 3<<20)
+		frame := compress(&zstdwrap.COptions{WindowLog: 23}, big)
+		if _, err := decompress(&zstdwrap.DOptions{WindowLogMax: 20}, frame); err == nil {
+			t.Error("Decompress succeeded with WindowLogMax 20")
+		}
+		if got, err := decompress(&zstdwrap.DOptions{WindowLogMax: 25}, frame); err != nil || !bytes.Equal(got, big) {
+			t.Errorf("WindowLogMax 25: %v", err)
+		}
+	})
+
+	t.Run("Dictionary", func(t *testing.T) {
+		dict, err := zstdwrap.TrainDictionary(4096, samples(2000, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := samples(1, 2)[0]
+		frame := compress(&zstdwrap.COptions{Dictionary: dict}, rec)
+		if _, err := decompress(nil, frame); err == nil {
+			t.Error("Decompress without the dictionary succeeded")
+		}
+		if got, err := decompress(&zstdwrap.DOptions{Dictionary: dict}, frame); err != nil || !bytes.Equal(got, rec) {
+			t.Errorf("Dictionary: %v", err)
+		}
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		frame := compress(&zstdwrap.COptions{Format: zstdwrap.FormatZstd1Magicless}, src)
+		if _, err := decompress(nil, frame); err == nil {
+			t.Error("Decompress of a magicless frame succeeded with FormatZstd1")
+		}
+		got, err := decompress(&zstdwrap.DOptions{Format: zstdwrap.FormatZstd1Magicless}, frame)
+		if err != nil || !bytes.Equal(got, src) {
+			t.Errorf("Format: %v", err)
+		}
+	})
+
+	t.Run("IgnoreChecksum", func(t *testing.T) {
+		frame := compress(&zstdwrap.COptions{Checksum: true}, src)
+		frame[len(frame)-1] ^= 0xff // last byte of the checksum
+		if _, err := decompress(nil, frame); !xerrors.Is(err, zstdwrap.ErrChecksumWrong) {
+			t.Errorf("Decompress err=%v, want ErrChecksumWrong", err)
+		}
+		got, err := decompress(&zstdwrap.DOptions{IgnoreChecksum: true}, frame)
+		if err != nil || !bytes.Equal(got, src) {
+			t.Errorf("IgnoreChecksum: %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := zstdwrap.NewDecompressorOpts(&zstdwrap.DOptions{Format: 7}); err == nil {
+			t.Error("NewDecompressorOpts with an unknown Format succeeded")
+		}
+	})
+}